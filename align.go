@@ -0,0 +1,42 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// alignMessages is set by SetAlignMessages.
+var alignMessages bool
+
+// SetAlignMessages sets whether the messages of entries start at the same
+// column, by padding the app name before them to the width of the app name of
+// the default Instance, such as for the entries of Instances with no or
+// shorter app names. Level tokens already take the same width, as set with
+// SetLevelWidth, so entries of custom levels with longer names line up too.
+func SetAlignMessages(on bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	alignMessages = on
+}
+
+// alignMessage pads an entry whose app name, with its brackets and separator,
+// took appWidth columns, if messages are being aligned. The caller must hold
+// writerMx.
+func alignMessage(b *bytes.Buffer, appWidth int) {
+	if !alignMessages {
+		return
+	}
+	if pad := appColumnWidth(std.app.Load()) - appWidth; pad > 0 {
+		b.WriteString(strings.Repeat(" ", pad))
+	}
+}
+
+// appColumnWidth returns the number of columns app takes in an entry, with its
+// brackets and separator. The caller must hold writerMx.
+func appColumnWidth(app string) int {
+	if app == "" || !showApp {
+		return 0
+	}
+	return utf8.RuneCountInString(appText(app)) + 2 + utf8.RuneCountInString(fieldSep)
+}
@@ -0,0 +1,70 @@
+package log
+
+// asyncQueue is the queue of writes done by the background goroutine in async
+// mode.
+type asyncQueue struct {
+	writes chan func()
+	done   chan struct{}
+}
+
+// async is the queue of the async mode, or nil when writes are synchronous.
+var async *asyncQueue
+
+// SetAsync makes entries be written by a background goroutine, through a
+// queue of up to size entries, so logging doesn't wait on a slow output until
+// the queue is full. A size of zero or less, the default, writes entries
+// synchronously.
+//
+// Changing the mode, or the outputs with SetOutput and SetLevelOutput, first
+// waits until every queued entry has been written to the output it was logged
+// to, so none are lost or written to the new output.
+func SetAsync(size int) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	stopAsync()
+	if size <= 0 {
+		return
+	}
+	async = &asyncQueue{
+		writes: make(chan func(), size),
+		done:   make(chan struct{}),
+	}
+	go func(q *asyncQueue) {
+		for w := range q.writes {
+			w()
+		}
+		close(q.done)
+	}(async)
+}
+
+// doWrite runs a write, queueing it in async mode. The caller must hold
+// writerMx.
+func doWrite(w func()) {
+	if async == nil {
+		w()
+		return
+	}
+	async.writes <- w
+}
+
+// drainAsync waits until every queued write has been done. The caller must
+// hold writerMx.
+func drainAsync() {
+	if async == nil {
+		return
+	}
+	drained := make(chan struct{})
+	async.writes <- func() { close(drained) }
+	<-drained
+}
+
+// stopAsync writes out the queue and stops the async goroutine. The caller
+// must hold writerMx.
+func stopAsync() {
+	if async == nil {
+		return
+	}
+	close(async.writes)
+	<-async.done
+	async = nil
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"strings"
+	"time"
+)
+
+// Entry is a log entry as its separate parts, as given to a ChannelWriter or
+// a Sink and read back by DecodeFrame.
+type Entry struct {
+	Time      time.Time
+	Level     Level
+	App       string
+	Subsystem string
+	// Msg is the message, with the fields of the entry except for a Sink.
+	Msg string
+	Loc string
+	// Fields are the fields of the entry for a Sink, and empty otherwise.
+	Fields []Field
+}
+
+// export returns the Entry for e.
+func (e *entry) export() Entry {
+	return Entry{
+		Time:      e.time,
+		Level:     e.level,
+		App:       e.instance().app.Load(),
+		Subsystem: e.subsystem,
+		Msg:       e.message(),
+		Loc:       e.loc,
+	}
+}
+
+// ChannelWriter is an output that sends each entry to a channel as an Entry,
+// such as for tests that check what was logged without parsing log lines.
+type ChannelWriter struct {
+	ch chan<- Entry
+}
+
+// NewChannelWriter returns a ChannelWriter that sends entries to ch. Set it as
+// an output with SetOutput, SetLevelOutput or AddOutput. Logging waits until
+// each entry is received, so ch should have room for all the entries of a test
+// if they are read after the logging is done.
+func NewChannelWriter(ch chan<- Entry) *ChannelWriter { return &ChannelWriter{ch} }
+
+// Write sends p as the message of an entry at the Info level.
+func (c *ChannelWriter) Write(p []byte) (n int, err error) {
+	c.ch <- Entry{
+		Time: time.Now(), Level: Info, Msg: strings.TrimSuffix(string(p), "\n"),
+	}
+	return len(p), nil
+}
+
+// writeEntry sends e to the channel.
+func (c *ChannelWriter) writeEntry(e *entry) error {
+	c.ch <- e.export()
+	return nil
+}
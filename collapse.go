@@ -0,0 +1,81 @@
+package log
+
+import (
+	"fmt"
+	"time"
+)
+
+// collapseDelay is how long a collapsed entry waits for more repeats before it
+// is written.
+const collapseDelay = time.Second
+
+var (
+	// collapseRepeats is set by SetCollapseRepeats.
+	collapseRepeats bool
+	// collapsed is the entry being held back while it repeats, and repeats
+	// the number of times it has been logged.
+	collapsed *entry
+	repeats   int
+	// collapseTimer writes the collapsed entry if no entry comes for a while.
+	collapseTimer *time.Timer
+)
+
+// SetCollapseRepeats sets whether consecutive entries with the same level,
+// message and code location, such as from a log statement in a loop, are
+// written as one line ending with (xN) for N repeats. The line is written when
+// a different entry comes, a second after the last repeat, or on Flush. Unlike
+// SetDedup there is no time window, and the location must match as well.
+func SetCollapseRepeats(on bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushCollapsed()
+	collapseRepeats = on
+}
+
+// collapse returns true if the entry is held back to be collapsed with its
+// repeats, writing out the entry held back before it if it is different. The
+// caller must hold writerMx.
+func collapse(e *entry) bool {
+	if !collapseRepeats {
+		return false
+	}
+	if c := collapsed; c != nil && c.level == e.level && c.loc == e.loc &&
+		c.inst == e.inst && c.message() == e.message() {
+		repeats++
+		collapseTimer.Reset(collapseDelay)
+		return true
+	}
+	flushCollapsed()
+	if e.level == Panic || e.level == Fatal {
+		// these are the last entries of the process.
+		return false
+	}
+	collapsed, repeats = e, 1
+	collapseTimer = time.AfterFunc(
+		collapseDelay, func() {
+			writerMx.Lock()
+			defer writerMx.Unlock()
+			if collapsed == e {
+				flushCollapsed()
+			}
+		},
+	)
+	return true
+}
+
+// flushCollapsed writes the entry held back by collapse, with the number of
+// times it was repeated. The caller must hold writerMx.
+func flushCollapsed() {
+	c := collapsed
+	if c == nil {
+		return
+	}
+	collapsed = nil
+	collapseTimer.Stop()
+	if repeats > 1 {
+		r := *c
+		r.msg, r.fields = fmt.Sprintf("%s (x%d)", c.message(), repeats), nil
+		c = &r
+	}
+	write(c)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package log
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// On Windows 10 and later the console only interprets the ANSI escapes used
+// for the level colors once virtual terminal processing is enabled. Legacy
+// consoles don't support it at all, so print without color there rather than
+// fill the screen with escape codes.
+func init() {
+	h := windows.Handle(os.Stderr.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		colorOff = true
+		return
+	}
+	mode |= windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING
+	if err := windows.SetConsoleMode(h, mode); err != nil {
+		colorOff = true
+	}
+}
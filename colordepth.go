@@ -0,0 +1,68 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/mleku/atomic"
+)
+
+// ColorDepth is the number of colors the level colors are printed with.
+type ColorDepth int32
+
+// The ColorDepth settings used with SetColorDepth
+const (
+	// Depth24 prints the exact 24-bit color of each level.
+	Depth24 ColorDepth = iota
+	// Depth256 prints the nearest of the 256 xterm colors.
+	Depth256
+	// Depth16 prints the nearest of the 16 basic ANSI colors.
+	Depth16
+	// DepthNone prints no colors.
+	DepthNone
+)
+
+// colorDepth is the ColorDepth the colorizers of LevelSpecs print with.
+var colorDepth = atomic.NewInt32(int32(detectColorDepth()))
+
+// SetColorDepth sets the number of colors the level colors are printed with,
+// in place of the one detected from the environment.
+func SetColorDepth(depth ColorDepth) { colorDepth.Store(int32(depth)) }
+
+// detectColorDepth guesses the colors the terminal supports from $COLORTERM and
+// $TERM. When neither says anything the colors are left at 24-bit.
+func detectColorDepth() ColorDepth {
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return Depth24
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case term == "":
+		return Depth24
+	case term == "dumb":
+		return DepthNone
+	case strings.Contains(term, "256color"):
+		return Depth256
+	}
+	return Depth16
+}
+
+// colorizer returns a function that prints in the color r, g, b, or the
+// nearest one that the current ColorDepth can show.
+func colorizer(r, g, b byte) func(format string, a ...interface{}) string {
+	c := color.RGB(r, g, b)
+	return func(format string, a ...interface{}) string {
+		switch ColorDepth(colorDepth.Load()) {
+		case Depth256:
+			return c.C256().Sprintf(format, a...)
+		case Depth16:
+			return c.Basic().Sprintf(format, a...)
+		case DepthNone:
+			return fmt.Sprintf(format, a...)
+		}
+		return c.Sprintf(format, a...)
+	}
+}
@@ -0,0 +1,173 @@
+package log
+
+import (
+	"io"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// Config is the configuration of the package as it was when Snapshot was
+// called, to be put back with Restore.
+//
+// It covers the settings of the default Instance, such as the log level,
+// output, timestamp format and app name, all the settings made with the Set
+// functions of the package, and the redactions and highlights. Levels
+// registered with RegisterLevel after the Snapshot are removed by Restore.
+// Hooks, sinks and outputs added with AddOutput are not part of it, as they
+// are removed with the functions that added them, and neither are the levels
+// of subsystems, the level names and colors, or async mode.
+type Config struct {
+	level             Level
+	levels            int
+	writer            io.Writer
+	levelWriters      map[Level]io.Writer
+	timeStampFormat   string
+	timeStampKind     int
+	timeStampMode     TimeStampMode
+	timeZone          *time.Location
+	app               string
+	appCase           AppCase
+	showApp           bool
+	fieldSep          string
+	clock             *func() time.Time
+	checkPrefix       string
+	maxMessageLength  int
+	spewConfig        *spew.ConfigState
+	plainMode         bool
+	colorMode         ColorMode
+	colorDepth        ColorDepth
+	levelStyle        LevelStyle
+	levelWidth        int
+	locFormat         LocFormat
+	callerMinLevel    int32
+	goroutineIDs      bool
+	sequenceNumbers   bool
+	preamble          *func() string
+	nilString         string
+	byteUnits         ByteUnits
+	messageFunc       *MessageFunc
+	alignMessages     bool
+	linePrefix        string
+	lineSuffix        string
+	dedupWindow       time.Duration
+	collapseRepeats   bool
+	globalFields      []field
+	redactions        []redaction
+	highlights        []highlight
+	spanExtractor     SpanExtractor
+	exitCode          int
+	exit              func(code int)
+	writeErrorHandler func(err error)
+}
+
+// Snapshot returns the current configuration, such as for a test to put back
+// when it is done with
+//
+//	defer log.Restore(log.Snapshot())
+func Snapshot() (c Config) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	c = Config{
+		level:            Level(std.level.Load()),
+		levels:           len(levelOrder),
+		writer:           std.writer,
+		levelWriters:     make(map[Level]io.Writer, len(levelWriters)),
+		timeStampFormat:  std.timeStampFormat,
+		timeStampKind:    std.timeStampKind,
+		timeStampMode:    timeStampMode,
+		timeZone:         timeZone,
+		app:              App.Load(),
+		appCase:          appCase,
+		showApp:          showApp,
+		fieldSep:         fieldSep,
+		clock:            clock.Load(),
+		checkPrefix:      checkPrefix,
+		maxMessageLength: maxMessageLength,
+		spewConfig:       spewConfig,
+		plainMode:        plainMode,
+		colorMode:        colorMode,
+		colorDepth:       ColorDepth(colorDepth.Load()),
+		levelStyle:       levelStyle,
+		levelWidth:       levelWidth,
+		locFormat:        LocFormat(locFormat.Load()),
+		callerMinLevel:   callerMinLevel.Load(),
+		goroutineIDs:     goroutineIDs,
+		sequenceNumbers:  sequenceNumbers,
+		preamble:         preamble.Load(),
+		nilString:        nilString.Load(),
+		byteUnits:        ByteUnits(byteUnits.Load()),
+		messageFunc:      messageFunc.Load(),
+		alignMessages:    alignMessages,
+		linePrefix:       linePrefix,
+		lineSuffix:       lineSuffix,
+		dedupWindow:      dedupWindow,
+		collapseRepeats:  collapseRepeats,
+		globalFields:     globalFields,
+		redactions:       append([]redaction(nil), redactions...),
+		highlights:       append([]highlight(nil), highlights...),
+		spanExtractor:    spanExtractor,
+		exitCode:         exitCode,
+		exit:             exit,
+	}
+	for level, w := range levelWriters {
+		c.levelWriters[level] = w
+	}
+	failMx.Lock()
+	c.writeErrorHandler = writeErrorHandler
+	failMx.Unlock()
+	return
+}
+
+// Restore puts back a configuration returned by Snapshot, all at once, so no
+// entry is printed with a mix of the old and new settings.
+func Restore(c Config) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushHeld()
+	unregisterLevels(c.levels)
+	std.level.Store(int32(c.level))
+	std.writer = c.writer
+	levelWriters = make(map[Level]io.Writer, len(c.levelWriters))
+	for level, w := range c.levelWriters {
+		levelWriters[level] = w
+	}
+	std.timeStampFormat = c.timeStampFormat
+	std.timeStampKind = c.timeStampKind
+	timeStampMode = c.timeStampMode
+	timeZone = c.timeZone
+	App.Store(c.app)
+	appCase = c.appCase
+	showApp = c.showApp
+	fieldSep = c.fieldSep
+	clock.Store(c.clock)
+	checkPrefix = c.checkPrefix
+	maxMessageLength = c.maxMessageLength
+	spewConfig = c.spewConfig
+	plainMode = c.plainMode
+	colorMode = c.colorMode
+	colorDepth.Store(int32(c.colorDepth))
+	levelStyle = c.levelStyle
+	levelWidth = c.levelWidth
+	locFormat.Store(int32(c.locFormat))
+	callerMinLevel.Store(c.callerMinLevel)
+	goroutineIDs = c.goroutineIDs
+	sequenceNumbers = c.sequenceNumbers
+	preamble.Store(c.preamble)
+	nilString.Store(c.nilString)
+	byteUnits.Store(int32(c.byteUnits))
+	messageFunc.Store(c.messageFunc)
+	alignMessages = c.alignMessages
+	linePrefix, lineSuffix = c.linePrefix, c.lineSuffix
+	dedupWindow = c.dedupWindow
+	globalFields = c.globalFields
+	collapseRepeats = c.collapseRepeats
+	redactions = append([]redaction(nil), c.redactions...)
+	highlights = append([]highlight(nil), c.highlights...)
+	spanExtractor = c.spanExtractor
+	exitCode = c.exitCode
+	exit = c.exit
+	failMx.Lock()
+	writeErrorHandler = c.writeErrorHandler
+	failMx.Unlock()
+}
@@ -0,0 +1,215 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dailyFileDate is the layout of the date in the names of daily log files.
+const dailyFileDate = "2006-01-02"
+
+type (
+	// DailyFileWriter is an output that writes to one file per day, named
+	// like app-2024-01-15.log, and deletes the files of days that are too
+	// old.
+	DailyFileWriter struct {
+		mx       sync.Mutex
+		dir      string
+		prefix   string
+		keepDays int
+		compress bool
+		date     string
+		file     *os.File
+		// compressMx is held while the files of past days are compressed,
+		// so only one goroutine does it at a time.
+		compressMx sync.Mutex
+		compressWg sync.WaitGroup
+	}
+	// DailyFileOption is an option for NewDailyFileWriter.
+	DailyFileOption func(d *DailyFileWriter)
+)
+
+// WithCompression sets whether the file of each past day is compressed with
+// gzip, into app-2024-01-15.log.gz, once the writer has moved on to the next
+// day. Compression is done in the background so it doesn't hold up logging.
+//
+// A compressed file replaces the file it was made from only once it is
+// complete, so if the process stops during compression the day is still in
+// the uncompressed file, which is compressed the next time the writer starts.
+func WithCompression(on bool) DailyFileOption {
+	return func(d *DailyFileWriter) { d.compress = on }
+}
+
+// NewDailyFileWriter returns a DailyFileWriter that writes to files in dir
+// named prefix-YYYY-MM-DD.log, for the current local date, appending to the
+// file of the day if it exists. A new file is started with the first write
+// after midnight, and then the files, compressed or not, more than keepDays
+// days older than it are deleted. A keepDays of zero or less keeps all files.
+// The date is taken from the clock, which can be set with SetClock.
+func NewDailyFileWriter(
+	dir, prefix string, keepDays int, opts ...DailyFileOption,
+) (d *DailyFileWriter, err error) {
+	d = &DailyFileWriter{dir: dir, prefix: prefix, keepDays: keepDays}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err = d.open(now()); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Write writes p to the file of the current date, starting a new file first
+// if the date has changed since the last write.
+func (d *DailyFileWriter) Write(p []byte) (n int, err error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if t := now(); t.Local().Format(dailyFileDate) != d.date {
+		if err = d.open(t); err != nil {
+			return
+		}
+		if line := preambleLine(); line != nil {
+			if _, err = d.file.Write(line); err != nil {
+				return
+			}
+		}
+	}
+	return d.file.Write(p)
+}
+
+// Sync commits the current file to storage.
+func (d *DailyFileWriter) Sync() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.file.Sync()
+}
+
+// Close closes the current file, after waiting for any compression to finish.
+func (d *DailyFileWriter) Close() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.compressWg.Wait()
+	return d.file.Close()
+}
+
+// open switches to the file for the date of t and deletes the old files,
+// first compressing the files of past days if compression is on. The caller
+// must hold d.mx.
+func (d *DailyFileWriter) open(t time.Time) (err error) {
+	date := t.Local().Format(dailyFileDate)
+	var f *os.File
+	if f, err = os.OpenFile(
+		d.name(date), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644,
+	); err != nil {
+		return
+	}
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+	d.file, d.date = f, date
+	if !d.compress {
+		d.prune(t)
+		return
+	}
+	d.compressWg.Add(1)
+	go func() {
+		defer d.compressWg.Done()
+		d.compressMx.Lock()
+		defer d.compressMx.Unlock()
+		d.compressPast(t)
+		d.prune(t)
+	}()
+	return
+}
+
+// name returns the name of the uncompressed file for date.
+func (d *DailyFileWriter) name(date string) string {
+	return filepath.Join(d.dir, d.prefix+"-"+date+".log")
+}
+
+// fileDate returns the date in the name of a daily file, compressed or not, or
+// false if name is not one.
+func (d *DailyFileWriter) fileDate(name string) (t time.Time, ok bool) {
+	date, found := strings.CutPrefix(filepath.Base(name), d.prefix+"-")
+	if !found {
+		return
+	}
+	date = strings.TrimSuffix(strings.TrimSuffix(date, ".gz"), ".log")
+	var err error
+	if t, err = time.ParseInLocation(dailyFileDate, date, time.Local); err != nil {
+		return
+	}
+	return t, true
+}
+
+// prune deletes the files of dates more than keepDays days before t.
+func (d *DailyFileWriter) prune(t time.Time) {
+	if d.keepDays <= 0 {
+		return
+	}
+	y, m, day := t.Local().Date()
+	oldest := time.Date(y, m, day-d.keepDays, 0, 0, 0, 0, time.Local)
+	for _, pattern := range []string{"-*.log", "-*.log.gz"} {
+		names, _ := filepath.Glob(filepath.Join(d.dir, d.prefix+pattern))
+		for _, name := range names {
+			if ft, ok := d.fileDate(name); ok && ft.Before(oldest) {
+				_ = os.Remove(name)
+			}
+		}
+	}
+}
+
+// compressPast compresses the uncompressed files of the dates before that of t,
+// and deletes any compressed file left incomplete by an earlier run. The
+// caller must hold d.compressMx.
+func (d *DailyFileWriter) compressPast(t time.Time) {
+	y, m, day := t.Local().Date()
+	today := time.Date(y, m, day, 0, 0, 0, 0, time.Local)
+	partial, _ := filepath.Glob(filepath.Join(d.dir, d.prefix+"-*.log.gz.tmp"))
+	for _, name := range partial {
+		_ = os.Remove(name)
+	}
+	names, _ := filepath.Glob(filepath.Join(d.dir, d.prefix+"-*.log"))
+	for _, name := range names {
+		if ft, ok := d.fileDate(name); ok && ft.Before(today) {
+			if compressFile(name) == nil {
+				_ = os.Remove(name)
+			}
+		}
+	}
+}
+
+// compressFile writes name compressed with gzip to name.gz, through a
+// temporary file that is renamed once it is complete, so that name.gz is
+// never left incomplete.
+func compressFile(name string) (err error) {
+	var src, dst *os.File
+	if src, err = os.Open(name); err != nil {
+		return
+	}
+	defer src.Close()
+	tmp := name + ".gz.tmp"
+	if dst, err = os.Create(tmp); err != nil {
+		return
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	return os.Rename(tmp, name+".gz")
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"fmt"
+	"time"
+)
+
+// dedupState is the last entry written while deduplication is on, and how
+// many identical entries have been suppressed since.
+type dedupState struct {
+	level      Level
+	msg, loc   string
+	inst       *Instance
+	subsystem  string
+	start      time.Time
+	suppressed int
+	timer      *time.Timer
+}
+
+var (
+	dedupWindow time.Duration
+	dedupLast   *dedupState
+)
+
+// SetDedup suppresses entries with the same level and message as the one
+// before them, if they come within window of the first of them. When a
+// different entry arrives, or the window closes, a line saying how many times
+// the message was repeated is printed in their place. Entries of different
+// Instances or subsystems are not repeats. The timestamp and location are not
+// compared. A window of zero, the default, turns this off.
+func SetDedup(window time.Duration) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushDedup()
+	dedupWindow = window
+}
+
+// dedup returns true if the entry repeats the last one and should not be
+// written. The caller must hold writerMx.
+func dedup(e *entry) (suppress bool) {
+	if dedupWindow <= 0 {
+		return
+	}
+	level, msg, loc := e.level, e.message(), e.loc
+	d := dedupLast
+	if d != nil && d.level == level && d.msg == msg && d.inst == e.inst &&
+		d.subsystem == e.subsystem && now().Sub(d.start) < dedupWindow {
+		d.suppressed++
+		if d.timer == nil {
+			d.timer = time.AfterFunc(
+				dedupWindow-now().Sub(d.start), func() {
+					writerMx.Lock()
+					defer writerMx.Unlock()
+					if dedupLast == d {
+						flushDedup()
+					}
+				},
+			)
+		}
+		return true
+	}
+	flushDedup()
+	dedupLast = &dedupState{
+		level: level, msg: msg, loc: loc, inst: e.inst, subsystem: e.subsystem,
+		start: now(),
+	}
+	return
+}
+
+// flushDedup writes the summary of any suppressed entries and forgets the last
+// entry. The caller must hold writerMx.
+func flushDedup() {
+	d := dedupLast
+	dedupLast = nil
+	if d == nil {
+		return
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if d.suppressed > 0 {
+		write(
+			&entry{
+				time:  now(),
+				level: d.level,
+				msg: fmt.Sprintf(
+					"last message repeated %d times", d.suppressed,
+				),
+				loc:       d.loc,
+				inst:      d.inst,
+				subsystem: d.subsystem,
+			},
+		)
+	}
+}
@@ -0,0 +1,94 @@
+package log
+
+import (
+	"strings"
+)
+
+// maxDiffCells is the most lines of the before dump times lines of the after
+// dump that SDiff compares, which bounds the memory the comparison takes.
+const maxDiffCells = 1 << 20
+
+var (
+	diffAdded   = colorizer(0, 255, 0)
+	diffRemoved = colorizer(255, 0, 0)
+)
+
+func _sdiff(c printerConfig) Differ {
+	c.diff = true
+	return func(before, after interface{}) {
+		if c.skip() {
+			return
+		}
+		logPrint(
+			c, func() string {
+				return spewDiff(
+					spewConfig.Sdump(before), spewConfig.Sdump(after),
+				)
+			},
+		)()
+	}
+}
+
+// spewDiff renders the lines that differ between two spew dumps, with added
+// lines marked with + and removed lines with -. Dumps too large to compare
+// render as the whole of the after dump.
+func spewDiff(before, after string) string {
+	if before == after {
+		return "no changes"
+	}
+	a := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+	if len(a)*len(b) > maxDiffCells {
+		return "too large to diff, now:\n" + strings.TrimSuffix(after, "\n")
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	o := []string{"diff:"}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			o = append(o, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			o = append(o, "- "+a[i])
+			i++
+		default:
+			o = append(o, "+ "+b[j])
+			j++
+		}
+	}
+	return strings.Join(o, "\n")
+}
+
+// colorDiff colors the added lines of a message from SDiff green and the
+// removed lines red, for text outputs with color.
+func colorDiff(msg string) string {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+ "):
+			lines[i] = diffAdded("%s", line)
+		case strings.HasPrefix(line, "- "):
+			lines[i] = diffRemoved("%s", line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,41 @@
+package log
+
+import "os"
+
+var (
+	// exitCode is the status the process exits with after a fatal entry.
+	exitCode = 1
+	// exit is the function set by SetExitFunc.
+	exit = os.Exit
+)
+
+// SetExitFunc sets the function that is called with the exit code after a
+// Fatal entry or an error given to Must, once the outputs are flushed. The
+// default, or passing nil, is os.Exit. Tests can set a function that records
+// the call, and programs one that shuts down cleanly before exiting. If the
+// function returns, the logging call returns as well.
+func SetExitFunc(fn func(code int)) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if fn == nil {
+		fn = os.Exit
+	}
+	exit = fn
+}
+
+// SetExitCode sets the status the process exits with after a Fatal entry or
+// an error given to Must. The default is 1.
+func SetExitCode(code int) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	exitCode = code
+}
+
+// fatalExit flushes the outputs and calls the exit function with exitCode.
+func fatalExit() {
+	_ = Flush()
+	writerMx.Lock()
+	fn, code := exit, exitCode
+	writerMx.Unlock()
+	fn(code)
+}
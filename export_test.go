@@ -0,0 +1,9 @@
+package log
+
+// These give the tests of package log_test access to internals.
+var (
+	ResetWatches = resetWatches
+	ResetOnce    = resetOnce
+	// JournaldSocket is where NewJournaldWriter connects to.
+	JournaldSocket = &journaldSocket
+)
@@ -0,0 +1,36 @@
+package log
+
+// globalFields are the fields set by SetGlobalFields.
+var globalFields []field
+
+// SetGlobalFields sets fields that are added to every entry, from a list of
+// alternating keys and values, such as the host name, process ID or
+// deployment, so they don't have to be added to each printer. They come
+// before the fields of the entry, and a field of the entry with the same key,
+// such as one added with WithError, takes the place of the global one.
+// Calling it with no pairs removes them.
+func SetGlobalFields(kv ...interface{}) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	globalFields = pairs(kv)
+}
+
+// withGlobalFields returns fields with the global fields put before them,
+// leaving out those whose key is also in fields. The caller must hold
+// writerMx.
+func withGlobalFields(fields []field) (all []field) {
+	if len(globalFields) == 0 {
+		return fields
+	}
+	all = make([]field, 0, len(globalFields)+len(fields))
+next:
+	for _, g := range globalFields {
+		for _, f := range fields {
+			if f.key == g.key {
+				continue next
+			}
+		}
+		all = append(all, g)
+	}
+	return append(all, fields...)
+}
@@ -6,9 +6,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/gookit/color v1.5.4
 	github.com/mleku/atomic v1.11.2
+	golang.org/x/sys v0.10.0
 )
 
-require (
-	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
-	golang.org/x/sys v0.10.0 // indirect
-)
+require github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
@@ -0,0 +1,32 @@
+package log
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goroutineIDs is set by SetGoroutineID.
+var goroutineIDs bool
+
+// SetGoroutineID sets whether each entry shows the ID of the goroutine that
+// printed it, as g=N between the level and the message. It is off by default,
+// as finding the ID means parsing a stack trace for every entry, so it is best
+// kept for debugging.
+func SetGoroutineID(on bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	goroutineIDs = on
+}
+
+// goroutineID returns the ID of the calling goroutine, parsed from the first
+// line of its stack trace, "goroutine N [running]:", or 0 if it can't be read.
+func goroutineID() (id uint64) {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		id, _ = strconv.ParseUint(string(b[:i]), 10, 64)
+	}
+	return
+}
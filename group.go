@@ -0,0 +1,73 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Group collects entries of a LevelPrinter and writes them out together, so
+// that the lines of one operation are not interleaved with those printed by
+// other goroutines at the same time.
+type Group struct {
+	c       printerConfig
+	mx      sync.Mutex
+	entries []*entry
+}
+
+// Group returns a Group that collects entries at the level of the
+// LevelPrinter until its Flush method is called, which is usually deferred.
+// Entries that are never flushed are not printed.
+func (lp LevelPrinter) Group() *Group { return &Group{c: lp.cfg} }
+
+// Ln adds an entry with the items of a joined by spaces to the Group.
+func (g *Group) Ln(a ...interface{}) {
+	if g.c.skip() {
+		return
+	}
+	var loc string
+	if hasLoc(g.c.level) {
+		loc = GetLoc(2)
+	}
+	g.add(joinStrings(" ", a...)(), loc)
+}
+
+// F adds an entry with the message formatted like fmt.Sprintf to the Group.
+func (g *Group) F(format string, a ...interface{}) {
+	if g.c.skip() {
+		return
+	}
+	var loc string
+	if hasLoc(g.c.level) {
+		loc = GetLoc(2)
+	}
+	g.add(fmt.Sprintf(format, a...), loc)
+}
+
+func (g *Group) add(msg, loc string) {
+	writerMx.Lock()
+	e := g.c.entry(msg, loc)
+	writerMx.Unlock()
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.entries = append(g.entries, e)
+}
+
+// Flush writes out the entries added to the Group since the last Flush, one
+// after the other with no other entries in between.
+func (g *Group) Flush() {
+	g.mx.Lock()
+	entries := g.entries
+	g.entries = nil
+	g.mx.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if !g.c.passes(Level(g.c.inst.level.Load())) {
+		return
+	}
+	for _, e := range entries {
+		emit(e)
+	}
+}
@@ -0,0 +1,40 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// StartHeartbeat prints msg at level every interval, so that operators can see
+// that the process is alive and its logging works. Nothing is printed while
+// level is not printed at the log level. The returned function stops the
+// heartbeat, and can be called more than once.
+func StartHeartbeat(interval time.Duration, level Level, msg string) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				writerMx.Lock()
+				if asSevere(level, Level(std.level.Load())) {
+					emit(&entry{time: now(), level: level, msg: msg})
+				}
+				writerMx.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(
+			func() {
+				ticker.Stop()
+				close(done)
+				<-stopped
+			},
+		)
+	}
+}
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// highlight is a pattern that AddHighlight colors in messages.
+type highlight struct {
+	pattern   *regexp.Regexp
+	colorizer func(string) string
+}
+
+// highlights are the patterns added with AddHighlight, in the order they were
+// added.
+var highlights []highlight
+
+// AddHighlight makes the text in messages that matches pattern be printed as
+// colorizer returns it, such as in a color of its own, for picking out IP
+// addresses or IDs when reading the log. Where matches of several patterns
+// overlap, the pattern added first wins. Highlights are only printed when the
+// level token is colored, and not when the whole line is in its level color.
+func AddHighlight(pattern *regexp.Regexp, colorizer func(string) string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	highlights = append(highlights, highlight{pattern, colorizer})
+}
+
+// highlightSpan is a match of a highlight in a message.
+type highlightSpan struct {
+	start, end int
+	colorizer  func(string) string
+}
+
+// applyHighlights returns msg with the matches of the highlights colored. The
+// caller must hold writerMx.
+func applyHighlights(msg string) string {
+	var spans []highlightSpan
+	for _, h := range highlights {
+	matches:
+		for _, m := range h.pattern.FindAllStringIndex(msg, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			for _, s := range spans {
+				if m[0] < s.end && s.start < m[1] {
+					continue matches
+				}
+			}
+			spans = append(spans, highlightSpan{m[0], m[1], h.colorizer})
+		}
+	}
+	if len(spans) == 0 {
+		return msg
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		b.WriteString(msg[last:s.start])
+		b.WriteString(s.colorizer(msg[s.start:s.end]))
+		last = s.end
+	}
+	b.WriteString(msg[last:])
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"io"
+
+	"github.com/mleku/atomic"
+)
+
+type (
+	// Instance is a logger with its own level, output, timestamp format and
+	// app name, so that a library can log without sharing the configuration
+	// of the application it is part of. The package level functions configure
+	// a default Instance that GetLogger uses. The other settings, such as the
+	// colors, hooks and outputs added with AddOutput, are shared by all
+	// instances, and only the default Instance writes to the outputs and the
+	// writers set with SetLevelOutput.
+	Instance struct {
+		// level is read without holding writerMx, so that printers of levels
+		// that are not printed return without waiting for it.
+		level           *atomic.Int32
+		writer          io.Writer
+		timeStampFormat string
+		timeStampKind   int
+		app             *atomic.String
+	}
+	// Option sets up an Instance made with New.
+	Option func(i *Instance)
+)
+
+// std is the default Instance, which the package level functions configure.
+var std = &Instance{
+	level:           atomic.NewInt32(int32(Info)),
+	writer:          tty,
+	timeStampFormat: defaultTimeStampFormat,
+	app:             &App,
+}
+
+// New returns an Instance that is configured by opts. Without options it logs
+// at Info to os.Stderr with the default timestamp format and no app name.
+func New(opts ...Option) (i *Instance) {
+	i = &Instance{
+		level:           atomic.NewInt32(int32(Info)),
+		writer:          tty,
+		timeStampFormat: defaultTimeStampFormat,
+		app:             atomic.NewString(""),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return
+}
+
+// WithLogLevel sets the log level of an Instance, clamped as by SetLogLevel.
+func WithLogLevel(level Level) Option {
+	return func(i *Instance) {
+		writerMx.Lock()
+		defer writerMx.Unlock()
+		i.level.Store(int32(clampLevel(level)))
+	}
+}
+
+// WithOutput sets the writer an Instance writes its entries to.
+func WithOutput(w io.Writer) Option { return func(i *Instance) { i.writer = w } }
+
+// WithTimeStampFormat sets the time layout of the timestamp of each entry of
+// an Instance.
+func WithTimeStampFormat(format string) Option {
+	return func(i *Instance) { i.timeStampFormat = format }
+}
+
+// WithApp sets the application name that an Instance prints in each entry.
+func WithApp(name string) Option { return func(i *Instance) { i.app.Store(name) } }
+
+// GetLogger returns a set of LevelPrinter that print with the Instance.
+func (i *Instance) GetLogger() (l *Logger) {
+	return newLogger(i, atomic.NewInt32(noLevel), "")
+}
+
+// SetLogLevel sets the log level of the Instance, clamped as by the package
+// SetLogLevel.
+func (i *Instance) SetLogLevel(level Level) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	i.level.Store(int32(clampLevel(level)))
+}
+
+// GetLogLevel returns the log level of the Instance.
+func (i *Instance) GetLogLevel() (level Level) { return Level(i.level.Load()) }
+
+// SetOutput sets the writer the Instance writes its entries to.
+func (i *Instance) SetOutput(w io.Writer) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushHeld()
+	i.writer = w
+	writePreamble(w)
+}
+
+// SetApp sets the application name the Instance prints in each entry.
+func (i *Instance) SetApp(name string) { i.app.Store(name) }
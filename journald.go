@@ -0,0 +1,108 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journaldSocket is where systemd's journal listens for the native protocol.
+// It is a variable so that tests can listen in its place.
+var journaldSocket = "/run/systemd/journal/socket"
+
+// Journald is an output that sends entries to the systemd journal using its
+// native protocol, so the level, message and code location are kept as
+// separate journal fields rather than flattened into one line as with syslog.
+type Journald struct {
+	mx   sync.Mutex
+	conn *net.UnixConn
+}
+
+// NewJournaldWriter connects to the systemd journal, returning an error if it
+// is not running. Set it as an output with SetOutput or SetLevelOutput.
+//
+// Each entry is sent as one datagram, so entries larger than the socket's
+// datagram size limit are dropped with an error.
+func NewJournaldWriter() (j *Journald, err error) {
+	var conn *net.UnixConn
+	if conn, err = net.DialUnix(
+		"unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"},
+	); err != nil {
+		return
+	}
+	return &Journald{conn: conn}, nil
+}
+
+// Write sends p to the journal as a message at the informational priority.
+func (j *Journald) Write(p []byte) (n int, err error) {
+	if err = j.send(
+		&entry{level: Info, msg: strings.TrimSuffix(string(p), "\n")},
+	); err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+// writeEntry sends an entry to the journal with its fields.
+func (j *Journald) writeEntry(e *entry) (err error) { return j.send(e) }
+
+// Close closes the connection to the journal.
+func (j *Journald) Close() error { return j.conn.Close() }
+
+func (j *Journald) send(e *entry) (err error) {
+	var b bytes.Buffer
+	journaldField(&b, "PRIORITY", journaldPriority(e.level))
+	journaldField(&b, "MESSAGE", e.message())
+	if i := strings.LastIndexByte(e.loc, ':'); i >= 0 {
+		journaldField(&b, "CODE_FILE", e.loc[:i])
+		journaldField(&b, "CODE_LINE", e.loc[i+1:])
+	}
+	app := e.instance().app.Load()
+	if app != "" {
+		journaldField(&b, "SYSLOG_IDENTIFIER", app)
+	}
+	// entries not from a subsystem Logger belong to the application as a
+	// whole.
+	if e.subsystem != "" {
+		journaldField(&b, "LOG_SUBSYSTEM", e.subsystem)
+	} else if app != "" {
+		journaldField(&b, "LOG_SUBSYSTEM", app)
+	}
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	_, err = j.conn.Write(b.Bytes())
+	return
+}
+
+// journaldField appends a field in the native protocol format, which has a
+// length prefixed form for values that contain newlines.
+func journaldField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journaldPriority maps a Level to a syslog priority number.
+func journaldPriority(level Level) string {
+	switch level {
+	case Panic, Fatal:
+		return "2"
+	case Error:
+		return "3"
+	case Check, Warn:
+		return "4"
+	case Info:
+		return "6"
+	}
+	return "7"
+}
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"bytes"
+	"io"
+)
+
+// levelDescriptions are the descriptions of the built-in levels printed by
+// PrintLevelLegend.
+var levelDescriptions = map[Level]string{
+	Panic: "an error that the program panics after",
+	Fatal: "an error that the program can't continue after",
+	Error: "an error that the program recovers from",
+	Check: "an error found by an error check",
+	Warn:  "something that may be a problem",
+	Info:  "the normal work of the program",
+	Debug: "details for debugging",
+	Trace: "fine details of the program flow",
+}
+
+// PrintLevelLegend writes a line for each level, in order from the most to the
+// least severe, with the level's name in its color and what it is used for,
+// such as for a help screen. Colors follow the same settings as log entries,
+// and are left out if w is a file other than a terminal.
+func PrintLevelLegend(w io.Writer) (err error) {
+	var b bytes.Buffer
+	writerMx.Lock()
+	color := colorFor(w)
+	for _, lvl := range levelOrder {
+		if lvl == Off {
+			continue
+		}
+		desc, ok := levelDescriptions[lvl]
+		if !ok {
+			desc = "a level added by the program"
+		}
+		b.WriteString(levelText(lvl, color))
+		b.WriteString("  ")
+		b.WriteString(desc)
+		b.WriteByte('\n')
+	}
+	writerMx.Unlock()
+	_, err = w.Write(b.Bytes())
+	return
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mleku/atomic"
+)
+
+var (
+	// levelOrder is the levels from the most to the least severe.
+	levelOrder = []Level{Off, Panic, Fatal, Error, Check, Warn, Info, Debug, Trace}
+	// ranks is the position of each Level in levelOrder, indexed by Level. It
+	// is replaced as a whole when a level is registered, so that it can be
+	// read without holding writerMx.
+	ranks atomic.Pointer[[]int]
+	// levelLabels are the labels returned by LevelLabel.
+	levelLabels = map[Level]string{
+		Off:   "off",
+		Panic: "panic",
+		Fatal: "fatal",
+		Error: "error",
+		Check: "check",
+		Warn:  "warn",
+		Info:  "info",
+		Debug: "debug",
+		Trace: "trace",
+	}
+)
+
+func init() { storeRanks() }
+
+// storeRanks updates ranks from levelOrder. The caller must hold writerMx.
+func storeRanks() {
+	r := make([]int, len(levelOrder))
+	for i, lvl := range levelOrder {
+		r[lvl] = i
+	}
+	ranks.Store(&r)
+}
+
+// storeLevelNameWidth sets levelNameWidth to the length of the longest level
+// name. The caller must hold writerMx.
+func storeLevelNameWidth() {
+	levelNameWidth = 0
+	for _, name := range LvlStr {
+		if n := utf8.RuneCountInString(name); n > levelNameWidth {
+			levelNameWidth = n
+		}
+	}
+}
+
+// rank returns the position of level in the severity order. Numbers that are
+// not levels rank before all levels if negative and after them otherwise.
+func rank(level Level) int {
+	r := *ranks.Load()
+	switch {
+	case level < 0:
+		return -1
+	case int(level) >= len(r):
+		return int(level)
+	}
+	return r[level]
+}
+
+// clampLevel returns level if it is a level, or else Off if it is below Off
+// and the least severe level if it is above the last one. The caller must hold
+// writerMx.
+func clampLevel(level Level) Level {
+	switch {
+	case level < Off:
+		return Off
+	case int(level) >= len(levelOrder):
+		return levelOrder[len(levelOrder)-1]
+	}
+	return level
+}
+
+// asSevere returns whether level is at least as severe as threshold, which is
+// whether entries of level are printed when the log level is threshold.
+func asSevere(level, threshold Level) bool { return rank(level) <= rank(threshold) }
+
+// RegisterLevel adds a level with the given name and color, and returns it.
+// The level's place in the severity order is just below the built-in Level
+// numbered severity, after any levels registered there before, so
+//
+//	Audit := log.RegisterLevel("aud", int(log.Warn), 0, 255, 255)
+//
+// is less severe than Warn and more severe than Info, and is printed when the
+// log level is Info or Audit or less severe. If the name is already a level,
+// that level is returned.
+func RegisterLevel(name string, severity int, r, g, b byte) (lvl Level) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if lvl, ok := lvlStrs[name]; ok {
+		return lvl
+	}
+	switch {
+	case severity < int(Off):
+		severity = int(Off)
+	case severity > int(Panic):
+		severity = int(Trace)
+	}
+	lvl = Level(len(levelOrder))
+	i := rank(Level(severity)) + 1
+	for i < len(levelOrder) && levelOrder[i] > Panic {
+		i++
+	}
+	order := make([]Level, 0, len(levelOrder)+1)
+	order = append(order, levelOrder[:i]...)
+	order = append(order, lvl)
+	levelOrder = append(order, levelOrder[i:]...)
+	storeRanks()
+	LvlStr[lvl] = name
+	lvlStrs[name] = lvl
+	levelLabels[lvl] = strings.ToLower(name)
+	LevelSpecs[lvl] = gLS(lvl, r, g, b)
+	storeLevelNameWidth()
+	return
+}
+
+// unregisterLevels removes the levels registered with RegisterLevel whose
+// number is n or more, as if they had never been registered. The caller must
+// hold writerMx.
+func unregisterLevels(n int) {
+	if n >= len(levelOrder) {
+		return
+	}
+	order := make([]Level, 0, n)
+	for _, lvl := range levelOrder {
+		if int(lvl) < n {
+			order = append(order, lvl)
+		}
+	}
+	levelOrder = order
+	for name, lvl := range lvlStrs {
+		if int(lvl) >= n {
+			delete(lvlStrs, name)
+		}
+	}
+	for lvl := range LvlStr {
+		if int(lvl) >= n {
+			delete(LvlStr, lvl)
+			delete(LevelSpecs, lvl)
+			delete(levelLabels, lvl)
+		}
+	}
+	storeRanks()
+	storeLevelNameWidth()
+}
+
+// LevelLabel returns a label for level for use outside of log lines, such as
+// the value of a level label of metrics: off, panic, fatal, error, check,
+// warn, info, debug or trace, or the name a level was registered with, in
+// lower case. Unlike the names printed in entries, labels are not changed by
+// SetLevelName or padded, and those of the built-in levels will stay the same
+// in future versions, so dashboards and alerts can rely on them. It returns ""
+// for a number that is not a level.
+func LevelLabel(level Level) string {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return levelLabels[level]
+}
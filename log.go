@@ -2,19 +2,30 @@
 package log
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
-	"github.com/gookit/color"
 	"github.com/mleku/atomic"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
-// The Level settings used in proc
+// The Level settings used in proc, from the most to the least severe, except
+// for Panic, which is more severe than Fatal but numbered after Trace, so that
+// the other levels keep the numbers they had before it was added. Panic
+// entries are followed by a panic with the message, and Fatal entries by a
+// call to the exit function set with SetExitFunc, whether they are printed or
+// not.
 const (
 	Off Level = iota
 	Fatal
@@ -24,21 +35,89 @@ const (
 	Info
 	Debug
 	Trace
+	Panic
 )
 
+// The ColorMode settings used with SetColorMode
+const (
+	// ColorLevel colorizes only the level token of each entry.
+	ColorLevel ColorMode = iota
+	// ColorFullLine colorizes the whole entry in the color of its level.
+	ColorFullLine
+)
+
+// The LevelStyle settings used with SetLevelStyle
+const (
+	// StyleFull prints the level names from LvlStr.
+	StyleFull LevelStyle = iota
+	// StyleShort prints the first letter of the level name, in upper case.
+	StyleShort
+)
+
+// The AppCase settings used with SetAppCase
+const (
+	// CaseUpper prints the app name in upper case.
+	CaseUpper AppCase = iota
+	// CaseAsIs prints the app name as it was set.
+	CaseAsIs
+	// CaseLower prints the app name in lower case.
+	CaseLower
+)
+
+// The TimeStampMode settings used with SetTimeStampMode
+const (
+	// TimeAbsolute prints the time of each entry in the configured format.
+	TimeAbsolute TimeStampMode = iota
+	// TimeRelative prints the time since the program started, or since the
+	// last ResetClock, such as +1.234s.
+	TimeRelative
+)
+
+// The LocFormat settings used with SetLocFormat
+const (
+	// LocFullPath prints the full path of the source file and the line.
+	LocFullPath LocFormat = iota
+	// LocFileLine prints the base name of the source file and the line.
+	LocFileLine
+	// LocFuncName prints the package qualified name of the function.
+	LocFuncName
+)
+
+// The kinds of timestamp set by SetTimeStampFormat and the timestamp presets
+const (
+	timeStampLayout = iota
+	timeStampEpoch
+	timeStampNone
+)
+
+// defaultTimeStampFormat is the time layout of timestamps until another is set.
+const defaultTimeStampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// noLevel marks a Logger with no level of its own.
+const noLevel = -1
+
+// maxPooledBuffer is the largest line buffer that is kept for reuse.
+const maxPooledBuffer = 64 << 10
+
+// continuationIndent is printed before each line of a message after the first.
+const continuationIndent = "    "
+
 // gLS is a helper to make more compact declarations of LevelSpec names and
 // colors by using the Level LvlStr map.
 func gLS(lvl Level, r, g, b byte) LevelSpec {
 	return LevelSpec{
 		Name:      LvlStr[lvl],
-		Colorizer: color.Bit24(r, g, b, false).Sprintf,
+		Colorizer: colorizer(r, g, b),
 	}
 }
 
 var (
-	// LevelSpecs specifies the id, string name and color-printing function
+	// LevelSpecs specifies the id, string name and color-printing function.
+	// Once logging has started, use GetLevelSpec and SetLevelSpec rather than
+	// the map, which is read while entries are printed.
 	LevelSpecs = map[Level]LevelSpec{
 		Off:   gLS(Off, 0, 0, 0),
+		Panic: gLS(Panic, 255, 0, 128),
 		Fatal: gLS(Fatal, 255, 0, 0),
 		Error: gLS(Error, 255, 128, 0),
 		Check: gLS(Check, 255, 255, 0),
@@ -49,14 +128,16 @@ var (
 	}
 
 	// LvlStr is a map that provides the uniform width strings that are printed
-	// to identify the Level of a log entry.
+	// to identify the Level of a log entry. Once logging has started, use
+	// GetLevelName and SetLevelName rather than the map.
 	LvlStr = LevelMap{
 		Off:   "off",
+		Panic: "pnc",
 		Fatal: "ftl",
 		Error: "err",
+		Check: "chk",
 		Warn:  "wrn",
 		Info:  "inf",
-		Check: "chk",
 		Debug: "dbg",
 		Trace: "trc",
 	}
@@ -65,6 +146,7 @@ var (
 	// relative paths for log printing code locations.
 	lvlStrs = map[string]Level{
 		"off": Off,
+		"pnc": Panic,
 		"ftl": Fatal,
 		"err": Error,
 		"chk": Check,
@@ -73,18 +155,73 @@ var (
 		"dbg": Debug,
 		"trc": Trace,
 	}
-	timeStampFormat           = "2006-01-02T15:04:05.000000000Z07:00"
-	tty             io.Writer = os.Stderr
-	writer                    = tty
-	writerMx        sync.Mutex
-	logLevel        = Info
+	timeStampMode           = TimeAbsolute
+	clockStart              = time.Now()
+	tty           io.Writer = os.Stderr
+	writerMx      sync.Mutex
+	// timeZone is the zone timestamps are shown in, or nil for local time.
+	timeZone *time.Location
+	// bufPool holds the buffers that log lines are built in.
+	bufPool      = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	levelWriters = map[Level]io.Writer{}
+	fieldSep     = " "
+	// clock is the function set by SetClock, or nil for time.Now. It is read
+	// without holding writerMx by writers such as DailyFileWriter.
+	clock       atomic.Pointer[func() time.Time]
+	checkPrefix = "CHECK:"
+	// maxMessageLength is the most runes of a message that are printed, or
+	// zero for no limit.
+	maxMessageLength int
+	spewConfig       = &spew.Config
+	// colorOff disables the level colors, for terminals that can't show them.
+	colorOff bool
+	// terminals records whether each file written to is a terminal, so that
+	// no escapes are written to other files.
+	terminals = map[*os.File]bool{}
+	// plainMode is set by SetPlain.
+	plainMode  bool
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	colorMode  = ColorLevel
+	levelStyle = StyleFull
+	// levelWidth is the width set by SetLevelWidth, or zero for the width of
+	// the longest level name, which is kept in levelNameWidth.
+	levelWidth     int
+	levelNameWidth = 3
+	appCase        = CaseUpper
+	// locFormat is the LocFormat set by SetLocFormat. It is read by GetLoc
+	// without holding writerMx.
+	locFormat atomic.Int32
+	// callerMinLevel is the level set by SetCallerMinLevel, or noLevel for
+	// all levels. It is read without holding writerMx.
+	callerMinLevel = atomic.NewInt32(noLevel)
+	// nilString is the text nil values are printed as.
+	nilString = atomic.NewString("<nil>")
 	// App is the name of the application. Change this at the beginning of
 	// an application main.
 	App atomic.String
+	// hooks are called in order on every entry that is printed.
+	hooks  []hook
+	hookID uint64
+	// counts is the number of entries written of each level.
+	counts = map[Level]uint64{}
+	// redactions is the list of patterns that are replaced in every log line
+	// before it is written, set via AddRedaction.
+	redactions []redaction
 )
 
 type (
 	LevelMap map[Level]string
+	// ColorMode selects which part of an entry is printed in the level color.
+	ColorMode int
+	// LevelStyle selects how the level of an entry is printed.
+	LevelStyle int
+	// AppCase selects the letter case the app name is printed in.
+	AppCase int
+	// TimeStampMode selects whether entries show the time or the time since
+	// the start.
+	TimeStampMode int
+	// LocFormat selects how the code location of an entry is printed.
+	LocFormat int32
 	// Level is a code representing a scale of importance and context for log
 	// entries.
 	Level int32
@@ -92,6 +229,10 @@ type (
 	Println func(a ...interface{})
 	// Printf prints like fmt.Println surrounded by log details
 	Printf func(format string, a ...interface{})
+	// PrintlnIf prints like Println if cond is true
+	PrintlnIf func(cond bool, a ...interface{})
+	// PrintfIf prints like Printf if cond is true
+	PrintfIf func(cond bool, format string, a ...interface{})
 	// Prints  prints a spew.Sdump for an interface slice
 	Prints func(a ...interface{})
 	// Printc accepts a function so that the extra computation can be avoided if
@@ -99,20 +240,92 @@ type (
 	Printc func(closure func() string)
 	// Chk is a shortcut for printing if there is an error, or returning true
 	Chk func(e error) bool
+	// ChkDo is Chk that also runs a cleanup function if there is an error
+	ChkDo func(e error, onErr func()) bool
+	// Chkf is Chk with a formatted description of what failed
+	Chkf func(e error, format string, a ...interface{}) bool
+	// Must logs an error and exits if there is one
+	Must func(e error)
+	// Errorf logs a message formatted like fmt.Errorf and returns it as an
+	// error
+	Errorf func(format string, a ...interface{}) error
+	// PrintKV prints key/value pairs sorted by key
+	PrintKV func(kv map[string]interface{})
+	// Differ prints the differences between spew dumps of two values
+	Differ func(before, after interface{})
+	// Watcher prints a spew dump of a value if it changed since the last time
+	Watcher func(key string, v interface{})
+	// Timer starts timing name and returns a function that logs the elapsed
+	// time when it is called
+	Timer func(name string) func()
 	// LevelPrinter defines a set of terminal printing primitives that output
 	// with extra data, time, level, and code location
 	LevelPrinter struct {
 		Ln Println
 		// F prints like fmt.Println surrounded by log details
 		F Printf
+		// LnIf is Ln that only prints if cond is true
+		LnIf PrintlnIf
+		// Once is Ln that only prints the first time it is called from each
+		// place in the code in the life of the process, such as for
+		// deprecation notices. Calls made while the level is not printed
+		// don't count
+		Once Println
+		// FIf is F that only prints if cond is true
+		FIf PrintfIf
 		// S uses spew.dump to show the content of a variable
 		S Prints
+		// KV prints a map as key=value pairs sorted by key
+		KV PrintKV
 		// C accepts a function so that the extra computation can be avoided if
 		// it is not being viewed
 		C Printc
 		// Chk is a shortcut for printing if there is an error, or returning
-		// true
+		// true. The errors it wraps follow on lines of their own unless their
+		// text is already in that of the error
 		Chk Chk
+		// ChkDo is Chk that also runs onErr if there is an error, such as to
+		// close a resource
+		ChkDo ChkDo
+		// Chkf is Chk that prints a formatted description before the error
+		Chkf Chkf
+		// Must logs the error with the code location and exits the process if
+		// there is one, such as log.F.Must(err) for errors that the program
+		// can't start without
+		Must Must
+		// Errorf logs the message and returns it as an error, for returning
+		// an error with the same text as the entry
+		Errorf Errorf
+		// Duration is used as defer log.T.Duration("name")() to log how long
+		// the enclosing function took, with the location of the defer
+		Duration Timer
+		// SDiff shows the lines that changed between spew dumps of two values
+		SDiff Differ
+		// SWatch shows a spew dump of v the first time it is called with key,
+		// and after that only when the dump is different from the last one
+		// for key
+		SWatch Watcher
+		cfg    printerConfig
+	}
+	// printerConfig is the set of parameters that the functions of a
+	// LevelPrinter are built with.
+	printerConfig struct {
+		level  Level
+		prefix string
+		// fields are key/value pairs printed after the message.
+		fields []field
+		// override is the level of the Logger the printer belongs to, which
+		// is used instead of the global level unless it is noLevel.
+		override *atomic.Int32
+		// inst is the Instance the printer prints with.
+		inst *Instance
+		// subsystem is the name of the subsystem of the Logger, if any.
+		subsystem string
+		// diff is whether the printer prints the diffs of SDiff.
+		diff bool
+		// nop is whether the printer is one of a Logger from NewNop, which
+		// never prints.
+		nop bool
 	}
 	// LevelSpec is a key pair of log level and the text colorizer used
 	// for it.
@@ -122,91 +335,819 @@ type (
 	}
 	// Logger is a set of log printers for the various Level items.
 	Logger struct {
-		F, E, W, I, D, T LevelPrinter
+		P, F, E, W, I, D, T LevelPrinter
+		// lvl is the level set by SetLevel, or noLevel.
+		lvl *atomic.Int32
+		// inst is the Instance the Logger prints with.
+		inst *Instance
+	}
+	// Hook is a function that is called with each entry that passes the level
+	// check, such as for counting errors in a metrics system.
+	Hook func(level Level, msg string, loc string)
+	hook struct {
+		id uint64
+		fn Hook
+	}
+	// field is a key/value pair added to the message of an entry.
+	field struct {
+		key   string
+		value interface{}
+	}
+	// entry is a log entry on its way to the outputs.
+	entry struct {
+		time  time.Time
+		level Level
+		// msg is the message, including any prefix.
+		msg    string
+		fields []field
+		loc    string
+		// inst is the Instance that printed the entry, or nil for the default
+		// Instance.
+		inst *Instance
+		// subsystem is the name of the subsystem that printed the entry, if
+		// any.
+		subsystem string
+		// goroutine is the ID of the goroutine that printed the entry, or 0
+		// if it is not shown.
+		goroutine uint64
+		// seq is the sequence number of the entry, or 0 if it is not shown.
+		seq uint64
+		// diff is whether the message is a diff from SDiff, whose added and
+		// removed lines are colored in text outputs with color.
+		diff bool
+	}
+	// entryWriter is an output that takes the parts of each entry separately
+	// rather than as a formatted line.
+	entryWriter interface {
+		writeEntry(e *entry) error
+	}
+	// redaction is a pattern and the text that replaces its matches.
+	redaction struct {
+		pattern     *regexp.Regexp
+		replacement string
 	}
 )
 
+// GetLevelByString returns the Level named by lvl, or def if there is no such
+// level. lvl may also be the number of a level, which is clamped to the range
+// Off to Trace, so Panic can only be given by name.
 func GetLevelByString(lvl string, def Level) (ll Level) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return getLevelByString(lvl, def)
+}
+
+// getLevelByString is GetLevelByString for callers that hold writerMx.
+func getLevelByString(lvl string, def Level) (ll Level) {
 	var exists bool
-	if ll, exists = lvlStrs[lvl]; !exists {
+	if ll, exists = lvlStrs[lvl]; exists {
+		return ll
+	}
+	n, err := strconv.Atoi(lvl)
+	if err != nil {
 		return def
 	}
-	return ll
+	switch {
+	case n < int(Off):
+		return Off
+	case n > int(Trace):
+		return Trace
+	}
+	return Level(n)
+}
+
+// SetLevelByString sets the log level from its name, in any case, or number,
+// returning an error if s is not a level.
+func SetLevelByString(s string) (err error) {
+	var ll Level
+	writerMx.Lock()
+	ll, err = parseLevel(s)
+	writerMx.Unlock()
+	if err != nil {
+		return
+	}
+	SetLogLevel(ll)
+	return
+}
+
+// parseLevel returns the level named by s, in any case, or numbered by s. The
+// caller must hold writerMx.
+func parseLevel(s string) (ll Level, err error) {
+	if ll = getLevelByString(strings.ToLower(strings.TrimSpace(s)), -1); ll < 0 {
+		err = fmt.Errorf("unknown log level %q, levels are: %s", s, LvlStr)
+	}
+	return
 }
 
 func GetLevelName(ll Level) string {
-	return strings.TrimSpace(LvlStr[ll])
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return levelName(ll)
 }
 
-// GetLoc calls runtime.Caller to get the path of the calling source code file.
+// levelName is GetLevelName for callers that hold writerMx.
+func levelName(ll Level) string { return strings.TrimSpace(LvlStr[ll]) }
+
+// SetLevelName changes the name that entries of a level are printed with and
+// that GetLevelByString finds it by.
+func SetLevelName(ll Level, name string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	setLevelName(ll, name)
+}
+
+// setLevelName is SetLevelName for callers that hold writerMx.
+func setLevelName(ll Level, name string) {
+	if lvlStrs[LvlStr[ll]] == ll {
+		delete(lvlStrs, LvlStr[ll])
+	}
+	LvlStr[ll] = name
+	lvlStrs[name] = ll
+	spec := LevelSpecs[ll]
+	spec.Name = name
+	LevelSpecs[ll] = spec
+	storeLevelNameWidth()
+}
+
+// GetLevelSpec returns the name and colorizer of a level.
+func GetLevelSpec(ll Level) LevelSpec {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return LevelSpecs[ll]
+}
+
+// SetLevelSpec changes the colorizer of a level, and its name if spec has one.
+// A nil Colorizer prints the level without color.
+func SetLevelSpec(ll Level, spec LevelSpec) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if spec.Name == "" {
+		spec.Name = LvlStr[ll]
+	} else {
+		setLevelName(ll, spec.Name)
+	}
+	if spec.Colorizer == nil {
+		spec.Colorizer = fmt.Sprintf
+	}
+	LevelSpecs[ll] = spec
+}
+
+// AllLevels returns the levels in severity order, from Off to Trace, including
+// any added with RegisterLevel. Their display names are available from
+// GetLevelName.
+func AllLevels() (levels []Level) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return append(levels, levelOrder...)
+}
+
+// GetLoc calls runtime.Caller to get the location of the calling source code,
+// in the format set by SetLocFormat. It returns an empty string if the caller
+// can't be found, and entries without a location leave it out.
 func GetLoc(skip int) (output string) {
-	_, file, line, _ := runtime.Caller(skip)
-	output = fmt.Sprint(file, ":", line)
-	return
+	pc, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return
+	}
+	return formatLoc(pc, file, line)
 }
 
+// formatLoc returns the location of the code at pc, in file at line, in the
+// format set by SetLocFormat.
+func formatLoc(pc uintptr, file string, line int) string {
+	switch LocFormat(locFormat.Load()) {
+	case LocFileLine:
+		file = filepath.Base(file)
+	case LocFuncName:
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name := fn.Name()
+			return name[strings.LastIndexByte(name, '/')+1:]
+		}
+	}
+	return fmt.Sprint(file, ":", line)
+}
+
+// SetCallerMinLevel makes only entries that are at least as severe as level
+// have a code location, such as log.Warn to skip the cost of finding the
+// location of every Debug and Trace entry. The default is to find the location
+// of all entries.
+func SetCallerMinLevel(level Level) { callerMinLevel.Store(int32(level)) }
+
+// hasLoc returns whether entries of level get a code location.
+func hasLoc(level Level) bool {
+	min := callerMinLevel.Load()
+	return min == noLevel || asSevere(level, Level(min))
+}
+
+// SetLocFormat sets how the code location of each entry is printed. The
+// default is LocFullPath.
+func SetLocFormat(format LocFormat) { locFormat.Store(int32(format)) }
+
 // GetLogger returns a set of LevelPrinter with their subsystem preloaded
-func GetLogger() (l *Logger) {
+func GetLogger() (l *Logger) { return std.GetLogger() }
+
+// newLogger returns a Logger of the named subsystem, if any, that prints with
+// the Instance i, whose level is kept in lvl.
+func newLogger(i *Instance, lvl *atomic.Int32, subsystem string) (l *Logger) {
+	p := func(level Level) LevelPrinter {
+		return newPrinter(
+			printerConfig{
+				level: level, override: lvl, inst: i, subsystem: subsystem,
+			},
+		)
+	}
+	return &Logger{
+		P:    p(Panic),
+		F:    p(Fatal),
+		E:    p(Error),
+		W:    p(Warn),
+		I:    p(Info),
+		D:    p(Debug),
+		T:    p(Trace),
+		lvl:  lvl,
+		inst: i,
+	}
+}
+
+// Clone returns a copy of the Logger with its own level, so that the level of
+// the copy can be changed with SetLevel without affecting the original or the
+// global level. The copy starts with the level of the original.
+func (l *Logger) Clone() (c *Logger) {
+	lvl := atomic.NewInt32(noLevel)
+	if l.lvl != nil {
+		lvl.Store(l.lvl.Load())
+	}
+	clone := func(lp LevelPrinter) LevelPrinter {
+		cfg := lp.cfg
+		cfg.override = lvl
+		return newPrinter(cfg)
+	}
 	return &Logger{
-		getOnePrinter(Fatal),
-		getOnePrinter(Error),
-		getOnePrinter(Warn),
-		getOnePrinter(Info),
-		getOnePrinter(Debug),
-		getOnePrinter(Trace),
+		P:    clone(l.P),
+		F:    clone(l.F),
+		E:    clone(l.E),
+		W:    clone(l.W),
+		I:    clone(l.I),
+		D:    clone(l.D),
+		T:    clone(l.T),
+		lvl:  lvl,
+		inst: l.inst,
 	}
 }
 
+// Printer returns a LevelPrinter of the Logger for any level, such as one
+// added with RegisterLevel.
+func (l *Logger) Printer(level Level) LevelPrinter {
+	cfg := l.T.cfg
+	cfg.level, cfg.prefix, cfg.fields = level, "", nil
+	return newPrinter(cfg)
+}
+
+// SetLevel sets a level for the Logger that is used instead of the global log
+// level, such as to turn a noisy component up to Trace on its own. Numbers
+// that are not levels are clamped as by SetLogLevel.
+func (l *Logger) SetLevel(level Level) {
+	if l.lvl != nil {
+		writerMx.Lock()
+		level = clampLevel(level)
+		writerMx.Unlock()
+		l.lvl.Store(int32(level))
+	}
+}
+
+// Enabled returns whether entries of the given level are currently printed, so
+// that work done only to build a log message can be skipped when it won't be.
+func Enabled(level Level) bool { return asSevere(level, GetLogLevel()) }
+
+// Log prints the items of a, joined by spaces, at level, the same as the Ln
+// printer of that level does, for when the level is only known at run time.
+func Log(level Level, a ...interface{}) {
+	c := printerConfig{level: level, inst: std}
+	if c.skip() {
+		return
+	}
+	logPrint(c, joinStrings(" ", a...))()
+}
+
+// Logf prints a message formatted like fmt.Sprintf at level, the same as the F
+// printer of that level does.
+func Logf(level Level, format string, a ...interface{}) {
+	c := printerConfig{level: level, inst: std}
+	if c.skip() {
+		return
+	}
+	logPrint(
+		c, func() string {
+			return fmt.Sprintf(format, a...)
+		},
+	)()
+}
+
+// SetLogLevel sets the least severe level that is printed. Numbers that are
+// not levels are clamped, so that below Off is Off and above the last level is
+// the least severe level, which is Trace unless one was registered after it.
 func SetLogLevel(l Level) {
 	writerMx.Lock()
 	defer writerMx.Unlock()
-	logLevel = l
+	std.level.Store(int32(clampLevel(l)))
+}
+
+func GetLogLevel() (l Level) { return std.GetLogLevel() }
+
+// SetLevelOutput routes entries of the given level to w instead of the writer
+// set by SetOutput, such as to send Info and Debug to os.Stdout while errors go
+// to os.Stderr. Passing a nil w sends the level back to the default writer.
+func SetLevelOutput(level Level, w io.Writer) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushHeld()
+	if w == nil {
+		delete(levelWriters, level)
+		return
+	}
+	levelWriters[level] = w
+	writePreamble(w)
+}
+
+// SetApp sets the application name that is printed in each log entry.
+func SetApp(name string) { App.Store(name) }
+
+// GetApp returns the application name that is printed in each log entry.
+func GetApp() string { return App.Load() }
+
+// SetAppCase sets the letter case the app name is printed in. The default is
+// CaseUpper.
+func SetAppCase(c AppCase) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	appCase = c
+}
+
+// SetOutput sets the writer that log entries are written to. The default is
+// os.Stderr. Entries are formatted whatever the writer, so with io.Discard the
+// cost of logging can be measured without that of the output, as
+// BenchmarkFormatting does.
+func SetOutput(w io.Writer) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushHeld()
+	std.writer = w
+	writePreamble(w)
+}
+
+// SetPlain turns plain mode on or off. In plain mode entries never contain
+// ANSI escapes, whatever the color settings and the output, which suits logs
+// that are written to files and read by other tools.
+func SetPlain(plain bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	plainMode = plain
+}
+
+// colorActive returns whether entries are colorized, which is unless the
+// terminal can't show colors, plain mode is on or the ColorDepth is DepthNone.
+// The caller must hold writerMx.
+func colorActive() bool {
+	return !colorOff && !plainMode && ColorDepth(colorDepth.Load()) != DepthNone
+}
+
+// colorFor returns whether entries written to w are colorized, which is when
+// colors are active and w is not a file other than a terminal. The caller must
+// hold writerMx.
+func colorFor(w io.Writer) bool {
+	if !colorActive() {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return true
+	}
+	term, ok := terminals[f]
+	if !ok {
+		fi, err := f.Stat()
+		term = err == nil && fi.Mode()&os.ModeCharDevice != 0
+		terminals[f] = term
+	}
+	return term
+}
+
+// ColorEnabled returns whether entries are currently printed in color to the
+// writer set with SetOutput, so that code that colors parts of its own
+// messages can leave the color out when it would end up as escapes in a file.
+// Outputs added with AddOutput can have color turned off on their own with
+// WithColor.
+func ColorEnabled() bool {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return colorFor(std.writer)
+}
+
+// SetColorMode sets which part of each entry is printed in the color of its
+// level. The default is ColorLevel. Either way nothing is colorized when
+// colors are off.
+func SetColorMode(mode ColorMode) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	colorMode = mode
+}
+
+// SetLevelStyle sets how the level of each entry is printed. The default is
+// StyleFull.
+func SetLevelStyle(style LevelStyle) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	levelStyle = style
+}
+
+// SetFieldSeparator sets the delimiter printed between the timestamp, app,
+// level, message and location of each entry. The default is a single space.
+func SetFieldSeparator(sep string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	fieldSep = sep
+}
+
+// SetClock sets the function used to get the time of each entry, so tests can
+// pin timestamps to a fixed instant. Passing nil restores time.Now.
+func SetClock(fn func() time.Time) {
+	if fn == nil {
+		clock.Store(nil)
+		return
+	}
+	clock.Store(&fn)
+}
+
+// now returns the time from the clock set with SetClock.
+func now() time.Time {
+	if fn := clock.Load(); fn != nil {
+		return (*fn)()
+	}
+	return time.Now()
+}
+
+// SetSpewConfig sets the spew configuration used by the S printers, such as to
+// disable pointer addresses or sort map keys. Passing nil restores the spew
+// defaults.
+func SetSpewConfig(c *spew.ConfigState) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if c == nil {
+		c = &spew.Config
+	}
+	spewConfig = c
+}
+
+// WithLevel sets the log level to l while fn runs and then restores the
+// previous level, even if fn panics.
+//
+// The log level is global, so while fn runs the level applies to every
+// goroutine, not only the one calling fn.
+func WithLevel(l Level, fn func()) {
+	prev := GetLogLevel()
+	SetLogLevel(l)
+	defer SetLogLevel(prev)
+	fn()
 }
 
-func GetLogLevel() (l Level) {
+// Flush writes out any entries queued in async mode or held back by
+// SetCollapseRepeats, and the summary of entries suppressed by SetDedup, and
+// makes the outputs write out any lines they have buffered, by calling their
+// Flush or Sync method if they have one, such as to make sure the last lines
+// reach a file before the program exits. os.Stderr and os.Stdout are not
+// buffered and are not synced.
+func Flush() (err error) {
 	writerMx.Lock()
 	defer writerMx.Unlock()
-	l = logLevel
+	flushHeld()
+	if err = flush(std.writer); err != nil {
+		return
+	}
+	for _, w := range levelWriters {
+		if err = flush(w); err != nil {
+			return
+		}
+	}
+	for _, o := range outputs {
+		if err = flush(o.w); err != nil {
+			return
+		}
+	}
 	return
 }
 
+// flushHeld writes the entry held back by SetCollapseRepeats and the summary of
+// entries suppressed by SetDedup, and waits for the queued writes, such as
+// before a writer is replaced so that they go to the writer that was set when
+// they were logged. The caller must hold writerMx.
+func flushHeld() {
+	flushCollapsed()
+	flushDedup()
+	drainAsync()
+}
+
+// flush is Flush for a single writer.
+func flush(w io.Writer) (err error) {
+	if w == os.Stderr || w == os.Stdout {
+		return
+	}
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Sync() error }:
+		return f.Sync()
+	}
+	return
+}
+
+// SetMaxMessageLength limits the message of each entry to n runes, cutting off
+// the rest with a note of how many runes were left out. The timestamp, level
+// and location are not counted. The default of zero means no limit.
+func SetMaxMessageLength(n int) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	maxMessageLength = n
+}
+
+// truncate cuts msg to the maximum message length. The caller must hold
+// writerMx.
+func truncate(msg string) string {
+	if maxMessageLength <= 0 || len(msg) <= maxMessageLength {
+		return msg
+	}
+	var runes int
+	for i := range msg {
+		if runes == maxMessageLength {
+			return fmt.Sprintf(
+				"%s…(truncated, %d more)", msg[:i],
+				utf8.RuneCountInString(msg[i:]),
+			)
+		}
+		runes++
+	}
+	return msg
+}
+
+// SetCheckPrefix sets the text printed before the error by the Chk printers.
+// The default is "CHECK:", and an empty prefix prints only the error.
+func SetCheckPrefix(prefix string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	checkPrefix = prefix
+}
+
 // SetTimeStampFormat sets a custom timeStampFormat for the logger
 func SetTimeStampFormat(format string) {
-	timeStampFormat = format
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	std.timeStampFormat = format
+	std.timeStampKind = timeStampLayout
+}
+
+// SetTimeStampMode sets whether entries show the time, in the format set by
+// SetTimeStampFormat or the timestamp presets, or the time since the program
+// started. The default is TimeAbsolute.
+func SetTimeStampMode(mode TimeStampMode) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	timeStampMode = mode
+}
+
+// SetTimeZone sets the time zone that timestamps are shown in, whatever the
+// zone of the host. The default, or a nil loc, is local time.
+func SetTimeZone(loc *time.Location) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	timeZone = loc
+}
+
+// SetUTC shows timestamps in UTC, such as to compare the logs of hosts in
+// different zones.
+func SetUTC() { SetTimeZone(time.UTC) }
+
+// ResetClock sets the start that TimeRelative timestamps count from to now.
+func ResetClock() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	clockStart = now()
+}
+
+// SetTimeStampRFC3339 sets the timestamp of each entry to the RFC 3339 format
+// with second precision.
+func SetTimeStampRFC3339() { SetTimeStampFormat(time.RFC3339) }
+
+// SetTimeStampEpoch sets the timestamp of each entry to the Unix time in
+// nanoseconds, written as an integer.
+func SetTimeStampEpoch() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	std.timeStampKind = timeStampEpoch
+}
+
+// SetTimeStampNone leaves the timestamp out of each entry.
+func SetTimeStampNone() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	std.timeStampKind = timeStampNone
+}
+
+// AddHook registers fn to be called, after any hooks added before it, with
+// every entry that passes the level check. It returns a function that removes
+// the hook again.
+//
+// Hooks run while the log output lock is held, so they must not log
+// themselves. A panic in a hook is recovered and ignored.
+func AddHook(fn Hook) (remove func()) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	hookID++
+	id := hookID
+	hooks = append(hooks, hook{id, fn})
+	return func() {
+		writerMx.Lock()
+		defer writerMx.Unlock()
+		for i := range hooks {
+			if hooks[i].id == id {
+				hooks = append(hooks[:i:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Counts returns the number of entries written of each level, since the start
+// or the last ResetCounts, such as for a metrics endpoint. Repeats held back by
+// SetDedup or SetCollapseRepeats are not counted, but the lines written in
+// their place are. Levels without entries are left out.
+func Counts() (c map[Level]uint64) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	c = make(map[Level]uint64, len(counts))
+	for lvl, n := range counts {
+		c[lvl] = n
+	}
+	return
+}
+
+// ResetCounts sets the counts returned by Counts back to zero.
+func ResetCounts() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	counts = map[Level]uint64{}
+}
+
+// AddRedaction registers a pattern whose matches are replaced with
+// replacement in every log line before it is written, such as bearer tokens or
+// passwords in spew dumps. Redactions are applied in the order they are added.
+//
+// This is a best-effort filter for accidental leaks, not a security guarantee:
+// anything the pattern does not match is printed as is.
+func AddRedaction(pattern *regexp.Regexp, replacement string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	redactions = append(redactions, redaction{pattern, replacement})
 }
 
+// String returns the names in the LevelMap in severity order, separated by
+// spaces.
 func (l LevelMap) String() (s string) {
-	ss := make([]string, len(l))
-	for i := range l {
-		ss[i] = strings.TrimSpace(l[i])
+	levels := make([]Level, 0, len(l))
+	for lvl := range l {
+		levels = append(levels, lvl)
+	}
+	sort.Slice(levels, func(i, j int) bool { return rank(levels[i]) < rank(levels[j]) })
+	ss := make([]string, len(levels))
+	for i, lvl := range levels {
+		ss[i] = strings.TrimSpace(l[lvl])
 	}
 	return strings.Join(ss, " ")
 }
 
-func _c(level Level) Printc {
+func _c(c printerConfig) Printc {
 	return func(closure func() string) {
-		logPrint(level, closure)()
+		logPrint(c, closure)()
 	}
 }
-func _chk(level Level) Chk {
+func _chk(c printerConfig) Chk {
 	return func(e error) (is bool) {
 		if e != nil {
-			logPrint(level,
-				joinStrings(
-					" ",
-					"CHECK:",
-					e,
-				))()
+			logPrint(c, checkMessage(e))()
 			is = true
 		}
 		return
 	}
 }
 
-func _f(level Level) Printf {
+// checkMessage returns the message printed by the check printers for e.
+func checkMessage(e error) func() string {
+	return func() string {
+		if checkPrefix == "" {
+			return errorChain(e)
+		}
+		return checkPrefix + " " + errorChain(e)
+	}
+}
+
+// errorChain returns the text of e followed by that of each error it wraps,
+// on lines of their own, leaving out those whose text is already in that of
+// the error wrapping them, as with fmt.Errorf and %w. An error with a Format
+// method is printed with %+v instead, which for most such errors shows the
+// causes and stack traces.
+func errorChain(e error) string {
+	if _, ok := e.(fmt.Formatter); ok {
+		return fmt.Sprintf("%+v", e)
+	}
+	var b strings.Builder
+	b.WriteString(sprintArg(e))
+	prev := e.Error()
+	for cause := errors.Unwrap(e); cause != nil; cause = errors.Unwrap(cause) {
+		if s := cause.Error(); !strings.Contains(prev, s) {
+			b.WriteByte('\n')
+			b.WriteString(s)
+		}
+		prev = cause.Error()
+	}
+	return b.String()
+}
+
+func _chkf(c printerConfig) Chkf {
+	return func(e error, format string, a ...interface{}) (is bool) {
+		if e != nil {
+			logPrint(
+				c, func() string {
+					return checkMessage(
+						fmt.Errorf("%s: %w", fmt.Sprintf(format, a...), e),
+					)()
+				},
+			)()
+			is = true
+		}
+		return
+	}
+}
+
+func _must(c printerConfig) Must {
+	return func(e error) {
+		if e == nil {
+			return
+		}
+		logPrint(c, func() string { return sprintArg(e) })()
+		if c.level != Fatal {
+			// Fatal printers have already exited.
+			fatalExit()
+		}
+	}
+}
+
+func _errorf(c printerConfig) Errorf {
+	return func(format string, a ...interface{}) (err error) {
+		err = fmt.Errorf(format, a...)
+		if c.skip() {
+			return
+		}
+		logPrint(c, func() string { return sprintArg(err) })()
+		return
+	}
+}
+
+func _chkdo(c printerConfig) ChkDo {
+	return func(e error, onErr func()) (is bool) {
+		if e != nil {
+			logPrint(c, checkMessage(e))()
+			onErr()
+			is = true
+		}
+		return
+	}
+}
+
+func _d(c printerConfig) Timer {
+	return func(name string) func() {
+		if !c.enabled() {
+			return func() {}
+		}
+		var loc string
+		if hasLoc(c.level) {
+			loc = GetLoc(2)
+		}
+		start := time.Now()
+		return func() {
+			writerMx.Lock()
+			defer writerMx.Unlock()
+			if !c.passes(Level(c.inst.level.Load())) {
+				return
+			}
+			emit(c.entry(fmt.Sprint(name, " took ", time.Since(start)), loc))
+		}
+	}
+}
+
+func _f(c printerConfig) Printf {
 	return func(format string, a ...interface{}) {
+		if c.skip() {
+			return
+		}
 		logPrint(
-			level, func() string {
+			c, func() string {
 				return fmt.Sprintf(format, a...)
 			},
 		)()
@@ -215,47 +1156,274 @@ func _f(level Level) Printf {
 
 // The collection of the different types of log print functions,
 // includes spew.Dump, closure and error check printers.
+//
+// The Ln, F and S printers check the level before building the message
+// closure, so arguments are not formatted, and their String methods are not
+// called, when the level is not being printed. LnIf and FIf also check their
+// condition first.
 
-func _ln(l Level) Println {
+func _ln(c printerConfig) Println {
 	return func(a ...interface{}) {
-		logPrint(l, joinStrings(" ", a...))()
+		if c.skip() {
+			return
+		}
+		logPrint(c, joinStrings(" ", a...))()
+	}
+}
+func _lnif(c printerConfig) PrintlnIf {
+	return func(cond bool, a ...interface{}) {
+		if !cond || c.skip() {
+			return
+		}
+		logPrint(c, joinStrings(" ", a...))()
+	}
+}
+
+func _fif(c printerConfig) PrintfIf {
+	return func(cond bool, format string, a ...interface{}) {
+		if !cond || c.skip() {
+			return
+		}
+		logPrint(
+			c, func() string {
+				return fmt.Sprintf(format, a...)
+			},
+		)()
 	}
 }
-func _s(level Level) Prints {
+
+func _s(c printerConfig) Prints {
 	return func(a ...interface{}) {
+		if c.skip() {
+			return
+		}
 		text := "spew:\n"
 		if s, ok := a[0].(string); ok {
 			text = strings.TrimSpace(s) + "\n"
 			a = a[1:]
 		}
 		logPrint(
-			level, func() string {
-				return text + spew.Sdump(a...)
+			c, func() string {
+				return text + spewConfig.Sdump(a...)
+			},
+		)()
+	}
+}
+
+func _kv(c printerConfig) PrintKV {
+	return func(kv map[string]interface{}) {
+		if c.skip() {
+			return
+		}
+		logPrint(
+			c, func() string {
+				keys := make([]string, 0, len(kv))
+				for k := range kv {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				var b strings.Builder
+				for i, k := range keys {
+					if i > 0 {
+						b.WriteByte(' ')
+					}
+					appendKV(&b, k, kv[k])
+				}
+				return b.String()
 			},
 		)()
 	}
 }
 
-func getOnePrinter(level Level) LevelPrinter {
+// newPrinter builds a LevelPrinter whose functions all print with the
+// parameters in c, or do nothing if c is that of a nop printer.
+func newPrinter(c printerConfig) LevelPrinter {
+	if c.nop {
+		return nopPrinter(c)
+	}
 	return LevelPrinter{
-		Ln:  _ln(level),
-		F:   _f(level),
-		S:   _s(level),
-		C:   _c(level),
-		Chk: _chk(level),
+		Ln:       _ln(c),
+		F:        _f(c),
+		LnIf:     _lnif(c),
+		Once:     _once(c),
+		FIf:      _fif(c),
+		S:        _s(c),
+		KV:       _kv(c),
+		C:        _c(c),
+		Chk:      _chk(c),
+		ChkDo:    _chkdo(c),
+		Chkf:     _chkf(c),
+		Must:     _must(c),
+		Errorf:   _errorf(c),
+		Duration: _d(c),
+		SDiff:    _sdiff(c),
+		SWatch:   _swatch(c),
+		cfg:      c,
+	}
+}
+
+// entry returns an entry from the printer with the message msg, with the
+// prefix and fields of the printer added. The caller must hold writerMx.
+func (c printerConfig) entry(msg, loc string) (e *entry) {
+	e = &entry{
+		time:      now(),
+		level:     c.level,
+		msg:       c.prefix + msg,
+		fields:    c.fields,
+		loc:       loc,
+		inst:      c.inst,
+		subsystem: c.subsystem,
+		diff:      c.diff,
+	}
+	if goroutineIDs {
+		e.goroutine = goroutineID()
+	}
+	return
+}
+
+// instance returns the Instance that printed the entry.
+func (e *entry) instance() *Instance {
+	if e.inst == nil {
+		return std
+	}
+	return e.inst
+}
+
+// message returns the message of the entry with its fields appended as
+// key=value pairs.
+func (e *entry) message() string {
+	if len(e.fields) == 0 {
+		return e.msg
+	}
+	var b strings.Builder
+	b.WriteString(e.msg)
+	for _, f := range e.fields {
+		b.WriteByte(' ')
+		appendKV(&b, f.key, f.value)
+	}
+	return b.String()
+}
+
+// appendKV writes a key=value pair to b, quoting the value if it contains
+// spaces.
+func appendKV(b *strings.Builder, key string, value interface{}) {
+	b.WriteString(key)
+	b.WriteByte('=')
+	v := sprintArg(value)
+	if strings.ContainsAny(v, " \t\n") {
+		v = strconv.Quote(v)
+	}
+	b.WriteString(v)
+}
+
+// passes returns whether entries from the printer are printed, given the
+// global log level.
+func (c printerConfig) passes(global Level) bool {
+	if c.override != nil {
+		if o := c.override.Load(); o != noLevel {
+			return asSevere(c.level, Level(o))
+		}
+	}
+	return asSevere(c.level, global)
+}
+
+// enabled returns whether entries from the printer are currently printed.
+func (c printerConfig) enabled() bool { return c.passes(c.inst.GetLogLevel()) }
+
+// skip returns whether the printer doesn't need to go on to logPrint, because
+// the entry will not be printed and the printer is not a Panic printer, which
+// panics with the message either way, or a Fatal printer, which exits either
+// way.
+func (c printerConfig) skip() bool {
+	return c.level != Panic && c.level != Fatal && !c.enabled()
+}
+
+// Enabled returns whether the LevelPrinter's entries are currently printed.
+func (lp LevelPrinter) Enabled() bool { return lp.cfg.enabled() }
+
+// with returns a copy of the LevelPrinter that adds fields from the key/value
+// pairs in kv to every entry.
+func (lp LevelPrinter) with(kv ...interface{}) LevelPrinter {
+	c := lp.cfg
+	c.fields = append(c.fields[:len(c.fields):len(c.fields)], pairs(kv)...)
+	return newPrinter(c)
+}
+
+// pairs makes fields from a list of alternating keys and values.
+func pairs(kv []interface{}) (fields []field) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, field{fmt.Sprint(kv[i]), kv[i+1]})
+	}
+	return
+}
+
+// WithError returns a copy of the LevelPrinter that adds err to every entry as
+// the field error, and if err wraps other errors, the innermost of them as
+// error.cause. If err is nil the LevelPrinter is returned as it is.
+func (lp LevelPrinter) WithError(err error) LevelPrinter {
+	if err == nil {
+		return lp
+	}
+	cause := err
+	for next := errors.Unwrap(cause); next != nil; next = errors.Unwrap(cause) {
+		cause = next
+	}
+	if cause == err {
+		return lp.with("error", err)
 	}
+	return lp.with("error", err, "error.cause", cause)
 }
 
-// getTimeText is a helper that returns the current time with the
-// timeStampFormat that is configured.
-func getTimeText(tsf string) string { return time.Now().Format(tsf) }
+// Prefix returns a copy of the LevelPrinter that prepends prefix to the
+// message of every entry it prints, leaving the original unchanged. Calling
+// Prefix on a prefixed printer adds to the existing prefix, and an empty prefix
+// adds nothing.
+func (lp LevelPrinter) Prefix(prefix string) LevelPrinter {
+	if prefix == "" {
+		return lp
+	}
+	c := lp.cfg
+	c.prefix += prefix + " "
+	return newPrinter(c)
+}
+
+// appendTimeText is a helper that writes t to b with the timestamp format of
+// the Instance i, or as configured by the timestamp presets, and returns false
+// if there is no timestamp.
+func appendTimeText(b *bytes.Buffer, t time.Time, i *Instance) bool {
+	if timeStampMode == TimeRelative {
+		b.WriteByte('+')
+		b.Write(
+			strconv.AppendFloat(
+				b.AvailableBuffer(), t.Sub(clockStart).Seconds(), 'f', 3, 64,
+			),
+		)
+		b.WriteByte('s')
+		return true
+	}
+	switch i.timeStampKind {
+	case timeStampEpoch:
+		b.Write(strconv.AppendInt(b.AvailableBuffer(), t.UnixNano(), 10))
+	case timeStampNone:
+		return false
+	default:
+		if timeZone != nil {
+			t = t.In(timeZone)
+		}
+		b.Write(t.AppendFormat(b.AvailableBuffer(), i.timeStampFormat))
+	}
+	return true
+}
 
 // joinStrings constructs a string from a slice of interface same as Println but
-// without the terminal newline
+// without the terminal newline, or with the function set by SetMessageFunc
 func joinStrings(sep string, a ...interface{}) func() (o string) {
 	return func() (o string) {
+		if fn := messageFunc.Load(); fn != nil {
+			return (*fn)(sep, a...)
+		}
 		for i := range a {
-			o += fmt.Sprint(a[i])
+			o += sprintArg(a[i])
 			if i < len(a)-1 {
 				o += sep
 			}
@@ -264,37 +1432,309 @@ func joinStrings(sep string, a ...interface{}) func() (o string) {
 	}
 }
 
+// SetNilString sets the text that nil values are printed as in messages and
+// fields. The default is <nil>, as fmt prints them. JSON output always uses
+// null.
+func SetNilString(s string) { nilString.Store(s) }
+
+// sprintArg formats a as fmt.Sprint does, except that a panic in its String or
+// Error method, such as from a nil pointer, gives a placeholder rather than
+// fmt's panic report, and nil is printed as set by SetNilString. It is used for
+// every value printed in text, so they all look the same.
+func sprintArg(a interface{}) (s string) {
+	switch v := a.(type) {
+	case nil:
+		return nilString.Load()
+	case error:
+		defer func() {
+			if recover() != nil {
+				s = "<!panic in Error()>"
+			}
+		}()
+		return v.Error()
+	case fmt.Stringer:
+		defer func() {
+			if recover() != nil {
+				s = "<!panic in String()>"
+			}
+		}()
+		return v.String()
+	}
+	return fmt.Sprint(a)
+}
+
+// FormatEntry composes a log line in the same format logPrint writes, from a
+// level, message and code location, without writing it anywhere. The result
+// has no trailing newline.
+func FormatEntry(level Level, msg string, loc string) string {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	return formatEntry(level, msg, loc)
+}
+
+// formatEntry is FormatEntry for callers that already hold writerMx.
+func formatEntry(level Level, msg string, loc string) (s string) {
+	var b bytes.Buffer
+	appendEntry(
+		&b, &entry{time: now(), level: level, msg: msg, loc: loc},
+		colorActive(),
+	)
+	return b.String()
+}
+
+// appendEntry writes an entry to b in the log line format, without a trailing
+// newline, colorized if color is true. The caller must hold writerMx.
+func appendEntry(b *bytes.Buffer, e *entry, color bool) {
+	start := b.Len()
+	level := e.level
+	// only the first line of the message goes before the location, further
+	// lines follow it, indented.
+	msg := strings.TrimRight(e.message(), "\n")
+	if color && e.diff {
+		// redact first, so that no escape can split a match of a redaction.
+		msg = colorDiff(redact(msg))
+	}
+	if color && colorMode == ColorLevel && len(highlights) > 0 {
+		// redact first, so that no escape can split a match of a redaction.
+		msg = applyHighlights(redact(msg))
+	}
+	var rest string
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg, rest = msg[:i], msg[i+1:]
+	}
+	if appendTimeText(b, e.time, e.instance()) {
+		b.WriteString(fieldSep)
+	}
+	app := e.instance().app.Load()
+	if app != "" && showApp {
+		b.WriteByte('[')
+		b.WriteString(appText(app))
+		b.WriteByte(']')
+		b.WriteString(fieldSep)
+	}
+	b.WriteString(levelText(level, color))
+	b.WriteString(fieldSep)
+	alignMessage(b, appColumnWidth(app))
+	if e.seq != 0 {
+		b.WriteByte('#')
+		b.Write(strconv.AppendUint(b.AvailableBuffer(), e.seq, 10))
+		b.WriteString(fieldSep)
+	}
+	if e.goroutine != 0 {
+		b.WriteString("g=")
+		b.Write(strconv.AppendUint(b.AvailableBuffer(), e.goroutine, 10))
+		b.WriteString(fieldSep)
+	}
+	b.WriteString(msg)
+	if e.loc != "" {
+		b.WriteString(fieldSep)
+		b.WriteString(e.loc)
+	}
+	if rest != "" {
+		b.WriteByte('\n')
+		b.WriteString(continuationIndent)
+		b.WriteString(
+			strings.ReplaceAll(rest, "\n", "\n"+continuationIndent),
+		)
+	}
+	fullLine := color && colorMode == ColorFullLine
+	if fullLine || plainMode || len(redactions) > 0 {
+		s := b.String()[start:]
+		if fullLine {
+			s = colorize(level, s)
+		}
+		if plainMode {
+			// escapes can also come from messages that were colorized by
+			// the caller.
+			s = ansiEscape.ReplaceAllString(s, "")
+		}
+		b.Truncate(start)
+		b.WriteString(redact(s))
+	}
+}
+
+// redact applies the redactions to s. The caller must hold writerMx.
+func redact(s string) string {
+	for _, r := range redactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
+
+// appText returns the app name in the configured case.
+func appText(app string) string {
+	switch appCase {
+	case CaseAsIs:
+		return app
+	case CaseLower:
+		return strings.ToLower(app)
+	}
+	return strings.ToUpper(app)
+}
+
+// levelText returns the level token of an entry, colorized if color is true
+// and the whole line is not being colorized.
+func levelText(level Level, color bool) string {
+	name := LvlStr[level]
+	var pad string
+	if levelStyle == StyleShort && name != "" {
+		r, _ := utf8.DecodeRuneInString(name)
+		name = strings.ToUpper(string(r))
+	} else {
+		name, pad = fitLevelName(name)
+	}
+	if !color || colorMode == ColorFullLine {
+		return name + pad
+	}
+	return colorize(level, name) + pad
+}
+
+// colorize returns s in the color of level, or as it is if the level has no
+// Colorizer. The caller must hold writerMx.
+func colorize(level Level, s string) string {
+	if c := LevelSpecs[level].Colorizer; c != nil {
+		return c("%s", s)
+	}
+	return s
+}
+
+// fitLevelName cuts name to the level width, or returns the spaces that pad it
+// out to the level width. The caller must hold writerMx.
+func fitLevelName(name string) (fit, pad string) {
+	width := levelWidth
+	if width == 0 {
+		width = levelNameWidth
+	}
+	n := utf8.RuneCountInString(name)
+	if n > width {
+		return string([]rune(name)[:width]), ""
+	}
+	return name, strings.Repeat(" ", width-n)
+}
+
+// SetLevelWidth sets the number of characters the level token of each entry
+// takes, padding shorter level names with spaces and cutting longer ones, so
+// that the messages line up. The default of zero uses the length of the
+// longest level name, including those added with RegisterLevel.
+func SetLevelWidth(n int) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	levelWidth = n
+}
+
 // logPrint is the generic log printing function that provides the base
-// format for log entries.
+// format for log entries. Fatal entries are followed by a call to the exit
+// function, after the output lock is released.
 func logPrint(
-	level Level,
+	c printerConfig,
 	printFunc func() string,
 ) func() {
 	return func() {
+		if c.level == Fatal {
+			defer fatalExit()
+		}
 		writerMx.Lock()
 		defer writerMx.Unlock()
-		if level > logLevel {
+		printed := c.passes(Level(c.inst.level.Load()))
+		if !printed && c.level != Panic {
 			return
 		}
-		timeText := getTimeText(timeStampFormat)
-		var loc string
-		loc = GetLoc(3)
-		formatString := "%s [%s] %s %s %s"
-		var app string
-		if len(App.Load()) > 0 {
-			app = App.Load()
-		}
-		s := fmt.Sprintf(
-			formatString,
-			timeText,
-			strings.ToUpper(app),
-			LevelSpecs[level].Colorizer(
-				LvlStr[level],
-			),
-			printFunc(),
-			loc,
-		)
-		s = strings.TrimSuffix(s, "\n")
-		_, _ = fmt.Fprintln(writer, s)
+		e := c.entry(printFunc(), "")
+		if printed {
+			if hasLoc(c.level) {
+				e.loc = GetLoc(3)
+			}
+			emit(e)
+		}
+		if c.level == Panic {
+			// the process may die of the panic before the background
+			// goroutine of async mode writes the entry.
+			drainAsync()
+			panic(redact(e.message()))
+		}
+	}
+}
+
+// emit writes an entry that has passed the level check and runs the hooks on
+// it. The caller must hold writerMx.
+func emit(e *entry) {
+	e.msg = truncate(e.msg)
+	e.fields = withGlobalFields(e.fields)
+	if sequenceNumbers {
+		e.seq = sequence.Add(1)
+	}
+	if !dedup(e) && !collapse(e) {
+		write(e)
+	}
+	if len(hooks) > 0 {
+		msg := e.message()
+		for _, h := range hooks {
+			runHook(h.fn, e.level, msg, e.loc)
+		}
+	}
+}
+
+// write writes an entry to the output for its level and to the outputs and
+// sinks added with AddOutput and AddSink. The caller must hold writerMx.
+func write(e *entry) {
+	counts[e.level]++
+	if i := e.instance(); i != std {
+		writeTo(i.writer, e, FormatText, colorFor(i.writer))
+		return
 	}
+	w := levelWriter(e.level)
+	writeTo(w, e, FormatText, colorFor(w))
+	for _, o := range outputs {
+		if asSevere(e.level, o.minLevel) {
+			writeTo(o.w, e, o.format, o.color && colorFor(o.w))
+		}
+	}
+	writeSinks(e)
+}
+
+// writeTo writes an entry to w in the given format. The caller must hold
+// writerMx.
+func writeTo(w io.Writer, e *entry, format Format, color bool) {
+	if ew, ok := w.(entryWriter); ok {
+		r := *e
+		r.msg, r.fields = redact(e.message()), nil
+		doWrite(func() { checkWrite(w, nil, ew.writeEntry(&r)) })
+		return
+	}
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	if format == FormatJSON {
+		appendJSON(b, e)
+	} else {
+		b.WriteString(linePrefix)
+		appendEntry(b, e, color)
+		b.WriteString(lineSuffix)
+	}
+	b.WriteByte('\n')
+	doWrite(
+		func() {
+			_, err := w.Write(b.Bytes())
+			checkWrite(w, b.Bytes(), err)
+			// don't keep the memory of rare huge entries around.
+			if b.Cap() <= maxPooledBuffer {
+				bufPool.Put(b)
+			}
+		},
+	)
+}
+
+// levelWriter returns the writer that entries of the level are written to.
+// The caller must hold writerMx.
+func levelWriter(level Level) io.Writer {
+	if w, ok := levelWriters[level]; ok {
+		return w
+	}
+	return std.writer
+}
+
+// runHook calls a hook, recovering from any panic in it so that a broken hook
+// can't take down the logging call.
+func runHook(fn Hook, level Level, msg, loc string) {
+	defer func() { _ = recover() }()
+	fn(level, msg, loc)
 }
@@ -1,9 +1,29 @@
 package log_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/davecgh/go-spew/spew"
 	l "github.com/mleku/log"
+	"io"
+	stdlog "log"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -12,6 +32,9 @@ var (
 )
 
 func TestGetLogger(t *testing.T) {
+	var exits int
+	l.SetExitFunc(func(int) { exits++ })
+	defer l.SetExitFunc(nil)
 	l.SetLogLevel(l.Trace)
 	l.App.Store("testing")
 	log.T.Ln("testing log level", l.LvlStr[l.Trace])
@@ -23,5 +46,2350 @@ func TestGetLogger(t *testing.T) {
 	fails(errors.New("dummy error as error"))
 	log.I.Chk(errors.New("dummy information check"))
 	log.I.Chk(nil)
+	if exits != 1 {
+		t.Fatalf("fatal entry exited %d times", exits)
+	}
 
 }
+
+func TestSetApp(t *testing.T) {
+	defer l.SetApp("testing")
+	l.SetApp("orders")
+	if app := l.GetApp(); app != "orders" || l.App.Load() != "orders" {
+		t.Fatalf("unexpected app %q", app)
+	}
+	if s := l.FormatEntry(l.Info, "message", "file.go:1"); !strings.Contains(s, " [ORDERS] ") {
+		t.Fatalf("unexpected entry %q", s)
+	}
+}
+
+func TestFormatEntry(t *testing.T) {
+	l.SetApp("testing")
+	s := l.FormatEntry(l.Info, "message", "file.go:1")
+	lvl := l.LevelSpecs[l.Info].Colorizer(l.LvlStr[l.Info])
+	if !strings.HasSuffix(s, " [TESTING] "+lvl+" message file.go:1") {
+		t.Fatalf("unexpected entry %q", s)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	log.I.Ln("one")
+	log.I.Ln("two")
+	log.I.Ln("three")
+	lines := r.Lines()
+	if len(lines) != 2 ||
+		!strings.Contains(lines[0], " two ") ||
+		!strings.Contains(lines[1], " three ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Trace)
+	func() {
+		defer log.T.Duration("work")()
+	}()
+	lines := r.Lines()
+	if len(lines) != 1 ||
+		!strings.Contains(lines[0], " work took ") ||
+		!strings.Contains(lines[0], "log_test.go:") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	l.SetLogLevel(l.Debug)
+	func() {
+		defer log.T.Duration("hidden")()
+	}()
+	if strings.Contains(r.Lines()[0], "hidden") {
+		t.Fatal("duration printed with trace disabled")
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	defer l.SetClock(nil)
+	s := l.FormatEntry(l.Info, "message", "file.go:1")
+	if !strings.HasPrefix(s, "1970-01-01T00:00:00.000000000Z ") {
+		t.Fatalf("unexpected timestamp in %q", s)
+	}
+}
+
+func TestMultiLineLayout(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.I.F("first\nsecond")
+	log.I.S("value", 1)
+	lines := r.Lines()
+	loc := regexp.MustCompile(`log_test\.go:\d+$`)
+	f := strings.Split(lines[0], "\n")
+	if len(f) != 2 ||
+		!strings.Contains(f[0], " first ") || !loc.MatchString(f[0]) ||
+		f[1] != "    second" {
+		t.Fatalf("unexpected F layout %q", lines[0])
+	}
+	s := strings.Split(lines[1], "\n")
+	if len(s) != 2 ||
+		!strings.Contains(s[0], " value ") || !loc.MatchString(s[0]) ||
+		s[1] != "    (int) 1" {
+		t.Fatalf("unexpected S layout %q", lines[1])
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	tagged := log.I.Prefix("[order-42]").Prefix("").Prefix("[item-7]")
+	tagged.Ln("shipped")
+	log.I.Ln("untagged")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " [order-42] [item-7] shipped ") ||
+		strings.Contains(lines[1], "[order-42]") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var order []string
+	removeFirst := l.AddHook(func(level l.Level, msg, loc string) {
+		order = append(order, "first:"+msg)
+	})
+	removePanic := l.AddHook(func(level l.Level, msg, loc string) {
+		panic("broken hook")
+	})
+	removeLast := l.AddHook(func(level l.Level, msg, loc string) {
+		order = append(order, "last:"+msg)
+	})
+	log.I.Ln("shown")
+	log.D.Ln("filtered")
+	removeFirst()
+	removePanic()
+	log.W.Ln("again")
+	removeLast()
+	log.W.Ln("unhooked")
+	if strings.Join(order, " ") != "first:shown last:shown last:again" {
+		t.Fatalf("unexpected hook calls %q", order)
+	}
+}
+
+type expensiveStringer struct{ calls *int }
+
+func (e expensiveStringer) String() string {
+	*e.calls++
+	return "expensive"
+}
+
+func TestGatedArgsNotFormatted(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var calls int
+	log.T.Ln(expensiveStringer{&calls})
+	log.T.F("%s", expensiveStringer{&calls})
+	if calls != 0 {
+		t.Fatalf("String called %d times for a disabled level", calls)
+	}
+	log.I.Ln(expensiveStringer{&calls})
+	if calls != 1 {
+		t.Fatalf("String called %d times for an enabled level", calls)
+	}
+}
+
+func BenchmarkGatedLn(b *testing.B) {
+	l.SetLogLevel(l.Info)
+	var calls int
+	s := expensiveStringer{&calls}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		log.T.Ln(s)
+	}
+	if calls != 0 {
+		b.Fatalf("String called %d times for a disabled level", calls)
+	}
+}
+
+func TestAllLevels(t *testing.T) {
+	var names []string
+	for _, lvl := range l.AllLevels() {
+		names = append(names, l.GetLevelName(lvl))
+	}
+	if strings.Join(names, " ") != "off pnc ftl err chk wrn inf dbg trc" {
+		t.Fatalf("unexpected levels %q", names)
+	}
+}
+
+func TestColorFullLine(t *testing.T) {
+	l.SetColorMode(l.ColorFullLine)
+	defer l.SetColorMode(l.ColorLevel)
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	defer l.SetClock(nil)
+	l.SetApp("testing")
+	s := l.FormatEntry(l.Info, "message", "file.go:1")
+	want := l.LevelSpecs[l.Info].Colorizer(
+		"%s", "1970-01-01T00:00:00.000000000Z [TESTING] inf message file.go:1",
+	)
+	if s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+}
+
+func TestGetLevelByString(t *testing.T) {
+	for s, want := range map[string]l.Level{
+		"dbg":  l.Debug,
+		"5":    l.Info,
+		"0":    l.Off,
+		"-3":   l.Off,
+		"99":   l.Trace,
+		"nope": l.Warn,
+	} {
+		if got := l.GetLevelByString(s, l.Warn); got != want {
+			t.Errorf("GetLevelByString(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestTimeStampPresets(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.SetApp("testing")
+	l.SetClock(func() time.Time { return time.Unix(1, 5).UTC() })
+	l.SetTimeStampEpoch()
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); !strings.HasPrefix(s, "1000000005 [") {
+		t.Errorf("unexpected epoch entry %q", s)
+	}
+	l.SetTimeStampRFC3339()
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); !strings.HasPrefix(s, "1970-01-01T00:00:01Z [") {
+		t.Errorf("unexpected RFC 3339 entry %q", s)
+	}
+	l.SetTimeStampNone()
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); !strings.HasPrefix(s, "[") {
+		t.Errorf("unexpected entry without timestamp %q", s)
+	}
+}
+
+func TestLvlStr(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		if s := l.LvlStr.String(); s != "off pnc ftl err chk wrn inf dbg trc" {
+			t.Fatalf("unexpected LvlStr %q", s)
+		}
+	}
+	for lvl, name := range l.LvlStr {
+		if len(name) != len(l.LvlStr[l.Off]) {
+			t.Errorf("level %d name %q is not uniform width", lvl, name)
+		}
+	}
+}
+
+type flushWriter struct {
+	io.Writer
+	flushed bool
+}
+
+func (f *flushWriter) Flush() error {
+	f.flushed = true
+	return nil
+}
+
+// flushNotifier is a writer that is closed when it is flushed.
+type flushNotifier chan struct{}
+
+func (f flushNotifier) Write(p []byte) (int, error) { return len(p), nil }
+
+func (f flushNotifier) Flush() error {
+	close(f)
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	w := &flushWriter{Writer: io.Discard}
+	l.SetOutput(w)
+	defer l.SetOutput(os.Stderr)
+	if err := l.Flush(); err != nil || !w.flushed {
+		t.Fatalf("writer not flushed, err %v", err)
+	}
+}
+
+func TestSetLevelOutput(t *testing.T) {
+	def, errs := l.RingBuffer(2), l.RingBuffer(2)
+	l.SetOutput(def)
+	defer l.SetOutput(os.Stderr)
+	l.SetLevelOutput(l.Error, errs)
+	defer l.SetLevelOutput(l.Error, nil)
+	l.SetLogLevel(l.Info)
+	log.I.Ln("info")
+	log.E.Ln("error")
+	if len(def.Lines()) != 1 || !strings.Contains(def.Lines()[0], " info ") ||
+		len(errs.Lines()) != 1 || !strings.Contains(errs.Lines()[0], " error ") {
+		t.Fatalf("misrouted entries %q %q", def.Lines(), errs.Lines())
+	}
+}
+
+func TestSetLevelByString(t *testing.T) {
+	defer l.SetLogLevel(l.Info)
+	if err := l.SetLevelByString("DBG"); err != nil || l.GetLogLevel() != l.Debug {
+		t.Fatalf("level %d, err %v", l.GetLogLevel(), err)
+	}
+	if err := l.SetLevelByString("verbose"); err == nil || l.GetLogLevel() != l.Debug {
+		t.Fatalf("unknown level accepted, level %d", l.GetLogLevel())
+	}
+}
+
+func TestGetLoc(t *testing.T) {
+	if loc := l.GetLoc(1); !strings.Contains(loc, "log_test.go:") {
+		t.Errorf("unexpected location %q", loc)
+	}
+	if loc := l.GetLoc(1000); loc != "" {
+		t.Errorf("unresolvable caller gave location %q", loc)
+	}
+	if s := l.FormatEntry(l.Info, "message", ""); !strings.HasSuffix(s, " message") {
+		t.Errorf("empty location not omitted in %q", s)
+	}
+}
+
+func TestLevelStyleShort(t *testing.T) {
+	l.SetLevelStyle(l.StyleShort)
+	defer l.SetLevelStyle(l.StyleFull)
+	s := l.FormatEntry(l.Warn, "message", "file.go:1")
+	if !strings.Contains(s, " "+l.LevelSpecs[l.Warn].Colorizer("W")+" message ") {
+		t.Fatalf("unexpected entry %q", s)
+	}
+	defer l.SetLevelName(l.Warn, l.GetLevelName(l.Warn))
+	l.SetLevelName(l.Warn, "äußerst")
+	s = l.FormatEntry(l.Warn, "message", "file.go:1")
+	if !utf8.ValidString(s) ||
+		!strings.Contains(s, " "+l.LevelSpecs[l.Warn].Colorizer("Ä")+" message ") {
+		t.Fatalf("unexpected entry %q", s)
+	}
+}
+
+func TestSetDedup(t *testing.T) {
+	r := l.RingBuffer(10)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetDedup(time.Hour)
+	for i := 0; i < 3; i++ {
+		log.E.Ln("retry failed")
+	}
+	log.E.Ln("gave up")
+	l.SetDedup(0)
+	lines := r.Lines()
+	if len(lines) != 3 ||
+		!strings.Contains(lines[0], " retry failed ") ||
+		!strings.Contains(lines[1], " last message repeated 2 times ") ||
+		!strings.Contains(lines[2], " gave up ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestFlushDedup(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(10)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetDedup(time.Hour)
+	for i := 0; i < 3; i++ {
+		log.E.Ln("retry failed")
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lines := r.Lines()
+	if len(lines) != 2 || !strings.Contains(lines[1], " last message repeated 2 times ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetDedupInstance(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r, ir := l.RingBuffer(10), l.RingBuffer(10)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetDedup(time.Hour)
+	inst := l.New(l.WithOutput(ir), l.WithLogLevel(l.Info)).GetLogger()
+	log.E.Ln("retry failed")
+	inst.E.Ln("retry failed")
+	inst.E.Ln("retry failed")
+	l.SetDedup(0)
+	if lines := r.Lines(); len(lines) != 1 {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if lines := ir.Lines(); len(lines) != 2 ||
+		!strings.Contains(lines[0], " retry failed ") ||
+		!strings.Contains(lines[1], " last message repeated 1 times ") {
+		t.Fatalf("unexpected Instance lines %q", lines)
+	}
+}
+
+func TestSetDedupWindowCloses(t *testing.T) {
+	r := l.RingBuffer(10)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetDedup(10 * time.Millisecond)
+	defer l.SetDedup(0)
+	log.E.Ln("retry failed")
+	log.E.Ln("retry failed")
+	time.Sleep(50 * time.Millisecond)
+	lines := r.Lines()
+	if len(lines) != 2 ||
+		!strings.Contains(lines[1], " last message repeated 1 times ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	l.SetLogLevel(l.Info)
+	if !l.Enabled(l.Warn) || !log.I.Enabled() || l.Enabled(l.Debug) ||
+		log.D.Enabled() {
+		t.Fatal("Enabled does not match the Info level")
+	}
+}
+
+func TestSDiff(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	type state struct{ A, B int }
+	log.I.SDiff(state{1, 2}, state{1, 3})
+	log.I.SDiff(state{1, 2}, state{1, 2})
+	lines := r.Lines()
+	if !strings.Contains(lines[0], "-  B: (int) 2") ||
+		!strings.Contains(lines[0], "+  B: (int) 3") ||
+		!strings.Contains(lines[0], "\n       A: (int) 1") ||
+		strings.Index(lines[0], "-  B") > strings.Index(lines[0], "+  B") ||
+		!strings.Contains(lines[1], " no changes ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSDiffColor(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	var j bytes.Buffer
+	defer l.AddOutput(&j, l.WithFormat(l.FormatJSON))()
+	l.SetLogLevel(l.Info)
+	l.SetColorDepth(l.Depth256)
+	type state struct{ A int }
+	log.I.SDiff(state{1}, state{2})
+	if line := r.Lines()[0]; !strings.Contains(line, "\x1b[38;5;10m+  A: (int) 2") ||
+		!strings.Contains(line, "\x1b[38;5;9m-  A: (int) 1") {
+		t.Fatalf("diff not colored at the color depth %q", line)
+	}
+	if strings.Contains(j.String(), "\x1b") || strings.Contains(j.String(), "\\u001b") {
+		t.Fatalf("escapes in JSON output %q", j.String())
+	}
+	var big []int
+	for i := 0; i < 2000; i++ {
+		big = append(big, i)
+	}
+	log.I.SDiff(big, append(big, 1))
+	if line := r.Lines()[0]; !strings.Contains(line, " too large to diff, now: ") {
+		t.Fatalf("large diff not skipped %q", line)
+	}
+}
+
+func TestSetAppCase(t *testing.T) {
+	l.SetApp("orderService")
+	defer l.SetApp("testing")
+	defer l.SetAppCase(l.CaseUpper)
+	for c, want := range map[l.AppCase]string{
+		l.CaseUpper: " [ORDERSERVICE] ",
+		l.CaseAsIs:  " [orderService] ",
+		l.CaseLower: " [orderservice] ",
+	} {
+		l.SetAppCase(c)
+		if s := l.FormatEntry(l.Info, "m", "f.go:1"); !strings.Contains(s, want) {
+			t.Errorf("case %d: unexpected entry %q", c, s)
+		}
+	}
+}
+
+func TestAppField(t *testing.T) {
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	defer l.SetClock(nil)
+	defer l.SetApp("testing")
+	lvl := l.LevelSpecs[l.Info].Colorizer(l.LvlStr[l.Info])
+	l.SetApp("")
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); s !=
+		"1970-01-01T00:00:00.000000000Z "+lvl+" m f.go:1" {
+		t.Errorf("unexpected entry without app %q", s)
+	}
+	l.SetApp("app")
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); s !=
+		"1970-01-01T00:00:00.000000000Z [APP] "+lvl+" m f.go:1" {
+		t.Errorf("unexpected entry with app %q", s)
+	}
+}
+
+func BenchmarkLn(b *testing.B) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		log.I.Ln("benchmark", i, "entry")
+	}
+}
+
+// BenchmarkFormatting compares the cost of formatting entries, with their
+// timestamp, location and colors, written to io.Discard, against that of also
+// writing them, one system call per entry as with os.Stderr, to the null
+// device so as not to fill the terminal.
+func BenchmarkFormatting(b *testing.B) {
+	defer l.Restore(l.Snapshot())
+	l.SetLogLevel(l.Info)
+	l.SetColorMode(l.ColorLevel)
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer null.Close()
+	for _, bm := range []struct {
+		name string
+		w    io.Writer
+	}{{"discard", io.Discard}, {"write", null}} {
+		b.Run(bm.name, func(b *testing.B) {
+			l.SetOutput(bm.w)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				log.I.Ln("benchmark", i, "entry")
+			}
+		})
+	}
+}
+
+func TestChkDo(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	var cleaned int
+	if log.E.ChkDo(nil, func() { cleaned++ }) || cleaned != 0 {
+		t.Fatal("ChkDo acted on a nil error")
+	}
+	if !log.E.ChkDo(errors.New("failed"), func() { cleaned++ }) || cleaned != 1 {
+		t.Fatal("ChkDo did not act on an error")
+	}
+}
+
+func TestLoggerClone(t *testing.T) {
+	r := l.RingBuffer(10)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	noisy := log.Clone()
+	noisy.SetLevel(l.Trace)
+	noisy.T.Ln("noisy trace")
+	log.T.Ln("quiet trace")
+	if lines := r.Lines(); len(lines) != 1 ||
+		!strings.Contains(lines[0], " noisy trace ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if l.GetLogLevel() != l.Info || log.T.Enabled() || !noisy.T.Enabled() {
+		t.Fatal("clone level leaked")
+	}
+}
+
+type spanKey struct{}
+
+func TestSpan(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetSpanExtractor(func(ctx context.Context) (string, string, bool) {
+		ids, ok := ctx.Value(spanKey{}).([2]string)
+		return ids[0], ids[1], ok
+	})
+	defer l.SetSpanExtractor(nil)
+	ctx := context.WithValue(context.Background(), spanKey{}, [2]string{"t1", "s1"})
+	log.I.Span(ctx).Ln("traced")
+	log.I.Span(context.Background()).Ln("untraced")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " traced trace_id=t1 span_id=s1 ") ||
+		!strings.Contains(lines[1], " untraced ") ||
+		strings.Contains(lines[1], "trace_id") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+// applyRuns counts the runs of TestApplyLevelConfig, to name subsystems that
+// haven't registered in earlier runs, as subsystems can't be unregistered.
+var applyRuns int
+
+func TestApplyLevelConfig(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	applyRuns++
+	netName, dbName := fmt.Sprint("net", applyRuns), fmt.Sprint("db", applyRuns)
+	net := l.GetSubsystemLogger(netName)
+	err := l.ApplyLevelConfig(map[string]string{
+		netName: "trc",
+		dbName:  "wrn",
+		"cache": "loud",
+	})
+	if err == nil ||
+		!strings.Contains(err.Error(), fmt.Sprintf("unknown subsystem %q", dbName)) ||
+		!strings.Contains(err.Error(), `subsystem "cache": unknown log level "loud"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	l.SetLogLevel(l.Info)
+	if !net.T.Enabled() {
+		t.Error("net level not applied")
+	}
+	if db := l.GetSubsystemLogger(dbName); db.I.Enabled() || !db.W.Enabled() {
+		t.Error("pending db level not applied on registration")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	defer l.SetLogLevel(l.Info)
+	for _, lvl := range []l.Level{l.Info, l.Off} {
+		l.SetLogLevel(lvl)
+		func() {
+			defer func() {
+				if p := recover(); p != "panicked 42" {
+					t.Errorf("level %d: unexpected panic value %v", lvl, p)
+				}
+			}()
+			log.P.F("panicked %d", 42)
+		}()
+	}
+	if lines := r.Lines(); len(lines) != 1 ||
+		!strings.Contains(lines[0], " panicked 42 ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+// slowWriter is a writer that takes a while to write to w.
+type slowWriter struct{ w io.Writer }
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	return s.w.Write(p)
+}
+
+func TestPanicAsync(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(1)
+	l.SetOutput(slowWriter{r})
+	l.SetLogLevel(l.Info)
+	l.SetAsync(4)
+	defer l.SetAsync(0)
+	func() {
+		defer func() { _ = recover() }()
+		log.P.Ln("panicked")
+	}()
+	if lines := r.Lines(); len(lines) != 1 || !strings.Contains(lines[0], " panicked ") {
+		t.Fatalf("panic entry not written before the panic, lines %q", lines)
+	}
+}
+
+func TestSetAsyncSwitch(t *testing.T) {
+	var first, second bytes.Buffer
+	l.SetOutput(&first)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetAsync(4)
+	for i := 0; i < 100; i++ {
+		log.I.Ln("first", i)
+	}
+	l.SetOutput(&second)
+	for i := 0; i < 100; i++ {
+		log.I.Ln("second", i)
+	}
+	l.SetAsync(0)
+	for name, b := range map[string]*bytes.Buffer{"first": &first, "second": &second} {
+		s := b.String()
+		if n := strings.Count(s, "\n"); n != 100 {
+			t.Errorf("%s output has %d lines, want 100", name, n)
+		}
+		for i := 0; i < 100; i++ {
+			if c := strings.Count(s, fmt.Sprint(" ", name, " ", i, " ")); c != 1 {
+				t.Errorf("%s line %d written %d times", name, i, c)
+			}
+		}
+	}
+}
+
+func TestTimeRelative(t *testing.T) {
+	at := time.Unix(100, 0)
+	l.SetClock(func() time.Time { return at })
+	defer l.SetClock(nil)
+	l.ResetClock()
+	l.SetTimeStampMode(l.TimeRelative)
+	defer l.SetTimeStampMode(l.TimeAbsolute)
+	at = at.Add(1234 * time.Millisecond)
+	if s := l.FormatEntry(l.Info, "m", "f.go:1"); !strings.HasPrefix(s, "+1.234s ") {
+		t.Fatalf("unexpected entry %q", s)
+	}
+}
+
+func TestAddOutput(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetApp("testing")
+	var remote, plain bytes.Buffer
+	removeRemote := l.AddOutput(&remote,
+		l.WithFormat(l.FormatJSON), l.WithMinLevel(l.Warn))
+	defer removeRemote()
+	removePlain := l.AddOutput(&plain, l.WithColor(false))
+	defer removePlain()
+	log.I.Ln("local only")
+	log.W.Ln("everywhere")
+	lines := strings.Split(strings.TrimSpace(remote.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("unexpected remote output %q", remote.String())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["level"] != "wrn" || m["msg"] != "everywhere" || m["app"] != "TESTING" ||
+		!strings.Contains(m["loc"].(string), "log_test.go:") {
+		t.Fatalf("unexpected JSON entry %v", m)
+	}
+	if strings.Count(plain.String(), "\n") != 2 ||
+		strings.Contains(plain.String(), "\x1b") {
+		t.Fatalf("unexpected plain output %q", plain.String())
+	}
+	remote.Reset()
+	// strconv.Quote would write the vertical tab as \v, which isn't JSON.
+	defer l.Restore(l.Snapshot())
+	l.SetTimeStampFormat("15:04\v")
+	slog.New(l.NewSlogHandler(l.Info)).Warn("fields", "msg", "field", "time", 1)
+	m = nil
+	if err := json.Unmarshal(remote.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["msg"] != "fields" || m["fields.msg"] != "field" ||
+		m["fields.time"] != 1.0 || !strings.HasSuffix(m["time"].(string), "\v") {
+		t.Fatalf("unexpected JSON entry %v", m)
+	}
+}
+
+type nilStringer struct{ name string }
+
+func (n *nilStringer) String() string { return n.name }
+
+func TestPanickingStringer(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var n *nilStringer
+	log.I.Ln("before", n, "after")
+	if lines := r.Lines(); len(lines) != 1 ||
+		!strings.Contains(lines[0], " before <!panic in String()> after ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Trace)
+	logger := slog.New(l.NewSlogHandler(l.Info))
+	logger.With("user", "u1").WithGroup("req").Warn("slow", "ms", 120)
+	logger.Debug("hidden")
+	lines := r.Lines()
+	if len(lines) != 1 ||
+		!strings.Contains(lines[0], l.LevelSpecs[l.Warn].Colorizer("wrn")+" slow user=u1 req.ms=120 ") ||
+		!strings.Contains(lines[0], "log_test.go:") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.ResetCounts()
+	log.E.Ln("one")
+	log.E.Ln("two")
+	log.W.Ln("three")
+	log.D.Ln("filtered")
+	c := l.Counts()
+	if len(c) != 2 || c[l.Error] != 2 || c[l.Warn] != 1 {
+		t.Fatalf("unexpected counts %v", c)
+	}
+	l.SetCollapseRepeats(true)
+	defer l.SetCollapseRepeats(false)
+	l.ResetCounts()
+	for i := 0; i < 2; i++ {
+		log.E.Ln("repeat")
+	}
+	l.Flush()
+	if c := l.Counts(); c[l.Error] != 1 {
+		t.Fatalf("collapsed repeat counted: %v", c)
+	}
+}
+
+func TestLnIf(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var calls int
+	log.W.LnIf(false, expensiveStringer{&calls})
+	log.W.FIf(false, "%s", expensiveStringer{&calls})
+	if calls != 0 || len(r.Lines()) != 0 {
+		t.Fatalf("false condition printed, String called %d times", calls)
+	}
+	log.W.LnIf(true, "ln", 1)
+	log.W.FIf(true, "f %d", 2)
+	if lines := r.Lines(); len(lines) != 2 ||
+		!strings.Contains(lines[0], " ln 1 ") ||
+		!strings.Contains(lines[1], " f 2 ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+type failWriter struct{ fail bool }
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("sink died")
+	}
+	return len(p), nil
+}
+
+func TestWriteErrorHandler(t *testing.T) {
+	w := &failWriter{fail: true}
+	l.SetOutput(w)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var reported []error
+	l.SetWriteErrorHandler(func(err error) { reported = append(reported, err) })
+	defer l.SetWriteErrorHandler(nil)
+	log.I.Ln("lost once")
+	log.I.Ln("lost twice")
+	w.fail = false
+	log.I.Ln("written")
+	w.fail = true
+	log.I.Ln("lost again")
+	if len(reported) != 2 || reported[0].Error() != "sink died" {
+		t.Fatalf("unexpected reports %v", reported)
+	}
+}
+
+func TestSetMaxMessageLength(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetMaxMessageLength(3)
+	defer l.SetMaxMessageLength(0)
+	log.I.Ln("héllo wörld")
+	log.I.Ln("abc")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " hél…(truncated, 8 more) ") ||
+		!strings.Contains(lines[1], " abc ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetPlain(t *testing.T) {
+	var b bytes.Buffer
+	l.SetOutput(&b)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	defer l.SetPlain(false)
+	l.SetColorMode(l.ColorFullLine)
+	defer l.SetColorMode(l.ColorLevel)
+	log.I.Ln("plain", l.LevelSpecs[l.Error].Colorizer("colored by caller"))
+	log.I.SDiff(1, 2)
+	if strings.Contains(b.String(), "\x1b") {
+		t.Fatalf("escape in plain output %q", b.String())
+	}
+}
+
+func TestKV(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.I.KV(map[string]interface{}{
+		"user":   "u1",
+		"action": "log in",
+		"err":    errors.New("bad password"),
+		"tries":  3,
+	})
+	want := ` action="log in" err="bad password" tries=3 user=u1 `
+	if lines := r.Lines(); !strings.Contains(lines[0], want) {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetCheckPrefix(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.E.Chk(errors.New("default"))
+	l.SetCheckPrefix("error check:")
+	defer l.SetCheckPrefix("CHECK:")
+	if !log.E.Chk(errors.New("custom")) {
+		t.Fatal("Chk returned false for an error")
+	}
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " CHECK: default ") ||
+		!strings.Contains(lines[1], " error check: custom ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+	c := l.Snapshot()
+	defer l.Restore(c)
+	audit := l.RegisterLevel("aud", int(l.Warn), 0, 255, 255)
+	if again := l.RegisterLevel("aud", int(l.Error), 0, 0, 0); again != audit {
+		t.Fatalf("registering aud twice gave %d and %d", audit, again)
+	}
+	if l.GetLevelByString("aud", l.Off) != audit || l.GetLevelName(audit) != "aud" {
+		t.Fatal("aud level not registered by name")
+	}
+	levels := l.AllLevels()
+	for i := range levels {
+		if levels[i] == audit &&
+			(levels[i-1] != l.Warn || levels[i+1] != l.Info) {
+			t.Fatalf("aud is not between wrn and inf in %v", levels)
+		}
+	}
+	defer l.SetLogLevel(l.Info)
+	l.SetLogLevel(l.Info)
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	log.Printer(audit).Ln("audited")
+	if lines := r.Lines(); len(lines) != 1 ||
+		!strings.Contains(lines[0], l.LevelSpecs[audit].Colorizer("aud")+" audited ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if !l.Enabled(audit) {
+		t.Error("aud not printed at inf")
+	}
+	l.SetLogLevel(l.Warn)
+	if l.Enabled(audit) {
+		t.Error("aud printed at wrn")
+	}
+	l.SetLogLevel(audit)
+	if !l.Enabled(l.Warn) || l.Enabled(l.Info) {
+		t.Error("filtering at aud is wrong")
+	}
+	l.Restore(c)
+	if l.GetLevelByString("aud", l.Off) != l.Off || len(l.AllLevels()) != 9 {
+		t.Fatalf("aud not removed by Restore, levels are %v", l.AllLevels())
+	}
+}
+
+func TestChkf(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var calls int
+	if log.E.Chkf(nil, "reading %s", expensiveStringer{&calls}) || calls != 0 {
+		t.Fatal("Chkf acted on a nil error")
+	}
+	if !log.E.Chkf(errors.New("not found"), "reading %s", "config.yml") {
+		t.Fatal("Chkf returned false for an error")
+	}
+	if lines := r.Lines(); !strings.Contains(lines[0], " CHECK: reading config.yml: not found ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetGoroutineID(t *testing.T) {
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.I.Ln("without")
+	l.SetGoroutineID(true)
+	defer l.SetGoroutineID(false)
+	log.I.Ln("here")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.I.Ln("there")
+	}()
+	<-done
+	lines := r.Lines()
+	if strings.Contains(lines[0], " g=") {
+		t.Fatalf("goroutine ID printed while disabled: %q", lines[0])
+	}
+	re := regexp.MustCompile(` g=(\d+) (here|there) `)
+	here, there := re.FindStringSubmatch(lines[1]), re.FindStringSubmatch(lines[2])
+	if here == nil || there == nil || here[1] == there[1] {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestNew(t *testing.T) {
+	var std bytes.Buffer
+	l.SetOutput(&std)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	lib := l.New(
+		l.WithLogLevel(l.Debug), l.WithApp("lib"),
+		l.WithTimeStampFormat("15:04"),
+	)
+	var out bytes.Buffer
+	lib.SetOutput(&out)
+	libLog := lib.GetLogger()
+	libLog.D.Ln("from the library")
+	log.D.Ln("from the app")
+	if !strings.Contains(out.String(), " [LIB] ") ||
+		!strings.Contains(out.String(), " from the library ") ||
+		!regexp.MustCompile(`^\d\d:\d\d `).MatchString(out.String()) {
+		t.Fatalf("unexpected library output %q", out.String())
+	}
+	if std.Len() != 0 {
+		t.Fatalf("default instance printed %q", std.String())
+	}
+	if l.GetLogLevel() != l.Info || lib.GetLogLevel() != l.Debug {
+		t.Fatal("instance levels are not independent")
+	}
+}
+
+func TestSetColorDepth(t *testing.T) {
+	defer l.SetColorDepth(l.Depth24)
+	for depth, want := range map[l.ColorDepth]string{
+		l.Depth24:   "\x1b[38;2;0;255;0minf\x1b[0m",
+		l.Depth256:  "\x1b[38;5;10minf\x1b[0m",
+		l.Depth16:   "\x1b[92minf\x1b[0m",
+		l.DepthNone: "inf",
+	} {
+		l.SetColorDepth(depth)
+		if got := l.LevelSpecs[l.Info].Colorizer("inf"); got != want {
+			t.Errorf("depth %d: got %q, want %q", depth, got, want)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	g := log.I.Group()
+	g.Ln("step", 1)
+	g.F("step %d", 2)
+	log.I.Ln("outside")
+	g.Flush()
+	g.Flush()
+	lines := r.Lines()
+	if len(lines) != 3 || !strings.Contains(lines[0], " outside ") ||
+		!strings.Contains(lines[1], " step 1 ") ||
+		!strings.Contains(lines[2], " step 2 ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if !strings.Contains(lines[1], "log_test.go:") {
+		t.Fatalf("group entry has no location: %q", lines[1])
+	}
+}
+
+func TestSetLocFormat(t *testing.T) {
+	defer l.SetLocFormat(l.LocFullPath)
+	for format, want := range map[l.LocFormat]*regexp.Regexp{
+		l.LocFullPath: regexp.MustCompile(`^.+[/\\]log_test\.go:\d+$`),
+		l.LocFileLine: regexp.MustCompile(`^log_test\.go:\d+$`),
+		l.LocFuncName: regexp.MustCompile(`^log_test\.TestSetLocFormat$`),
+	} {
+		l.SetLocFormat(format)
+		if loc := l.GetLoc(1); !want.MatchString(loc) {
+			t.Errorf("format %d: unexpected location %q", format, loc)
+		}
+	}
+}
+
+func TestSlogLocFormat(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	logger := slog.New(l.NewSlogHandler(l.Info))
+	l.SetLocFormat(l.LocFileLine)
+	logger.Info("file and line")
+	l.SetLocFormat(l.LocFuncName)
+	logger.Info("function")
+	lines := r.Lines()
+	if !regexp.MustCompile(` file and line log_test\.go:\d+$`).MatchString(lines[0]) ||
+		!strings.HasSuffix(lines[1], " function log_test.TestSlogLocFormat") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to the process on windows")
+	}
+	w := make(flushNotifier)
+	l.SetOutput(w)
+	defer l.SetOutput(os.Stderr)
+	// the test has its own handler, so the raised signal doesn't stop it.
+	own := make(chan os.Signal, 2)
+	signal.Notify(own, os.Interrupt)
+	defer signal.Stop(own)
+	defer l.FlushOnSignal(os.Interrupt)()
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w:
+	case <-time.After(5 * time.Second):
+		t.Fatal("output not flushed on the signal")
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-own:
+		case <-time.After(5 * time.Second):
+			t.Fatal("signal not raised again")
+		}
+	}
+}
+
+func TestNewNop(t *testing.T) {
+	var b bytes.Buffer
+	l.SetOutput(&b)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Trace)
+	defer l.SetLogLevel(l.Info)
+	nop := l.NewNop()
+	var calls int
+	nop.E.Ln("never", expensiveStringer{&calls})
+	nop.T.F("never %v", expensiveStringer{&calls})
+	nop.I.Prefix("sub").Ln("never")
+	traced := l.WithRequestLevel(context.Background(), l.Trace)
+	nop.E.Ctx(traced).Ln("never")
+	nop.W.WithError(errors.New("never")).Ln("never")
+	clone := nop.Clone()
+	clone.SetLevel(l.Trace)
+	clone.I.Ln("never")
+	nop.Printer(l.Info).Ln("never")
+	nop.D.Duration("never")()
+	if !nop.E.Chk(errors.New("still checked")) || nop.E.Chk(nil) {
+		t.Fatal("Chk of the nop logger doesn't report errors")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("P of the nop logger didn't panic")
+			}
+		}()
+		nop.P.Ln("panic")
+	}()
+	if b.Len() != 0 || calls != 0 {
+		t.Fatalf("nop logger printed %q, %d String calls", b.String(), calls)
+	}
+}
+
+func TestSetLevelWidth(t *testing.T) {
+	l.SetPlain(true)
+	defer l.SetPlain(false)
+	defer l.SetLevelWidth(0)
+	for width, want := range map[int]string{
+		0: " inf message",
+		5: " inf   message",
+		2: " in message",
+	} {
+		l.SetLevelWidth(width)
+		if s := l.FormatEntry(l.Info, "message", ""); !strings.Contains(s, want) {
+			t.Errorf("width %d: got %q, want %q", width, s, want)
+		}
+	}
+	l.SetLevelName(l.Trace, "trace")
+	defer l.SetLevelName(l.Trace, "trc")
+	l.SetLevelWidth(4)
+	l.SetTimeStampNone()
+	defer l.SetTimeStampFormat("2006-01-02T15:04:05.000000000Z07:00")
+	column := -1
+	for _, lvl := range []l.Level{l.Fatal, l.Info, l.Trace} {
+		s := l.FormatEntry(lvl, "message", "")
+		if i := strings.Index(s, "message"); column >= 0 && i != column {
+			t.Fatalf("messages don't line up: %q", s)
+		} else {
+			column = i
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	var main, errs bytes.Buffer
+	l.SetOutput(&main)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	remove := l.AddOutput(&errs, l.WithMinLevel(l.Error))
+	defer remove()
+	l.Raw(l.Debug, []byte(`{"msg":"hidden"}`))
+	l.Raw(l.Info, []byte(`{"msg":"info"}`))
+	l.Raw(l.Error, []byte("{\"msg\":\"error\"}\n"))
+	if main.String() != "{\"msg\":\"info\"}\n{\"msg\":\"error\"}\n" {
+		t.Fatalf("unexpected output %q", main.String())
+	}
+	if errs.String() != "{\"msg\":\"error\"}\n" {
+		t.Fatalf("unexpected error output %q", errs.String())
+	}
+}
+
+func TestMust(t *testing.T) {
+	if os.Getenv("LOG_TEST_MUST") == "1" {
+		l.SetExitCode(3)
+		log.F.Must(nil)
+		log.F.Must(errors.New("config not found"))
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMust$")
+	cmd.Env = append(os.Environ(), "LOG_TEST_MUST=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("unexpected result %v", err)
+	}
+	if !strings.Contains(stderr.String(), " config not found ") ||
+		strings.Count(stderr.String(), "\n") != 1 {
+		t.Fatalf("unexpected output %q", stderr.String())
+	}
+}
+
+func TestLevelSpecAccessors(t *testing.T) {
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	spec := l.GetLevelSpec(l.Warn)
+	defer l.SetLevelSpec(l.Warn, spec)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.SetLevelSpec(l.Warn, l.LevelSpec{
+				Name:      fmt.Sprint("w", i%10),
+				Colorizer: spec.Colorizer,
+			})
+			l.SetLevelName(l.Warn, "wrn")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		log.W.Ln("while the spec changes")
+		_ = l.GetLevelName(l.Warn)
+	}
+	<-done
+	if l.GetLevelName(l.Warn) != "wrn" || l.GetLevelByString("wrn", l.Off) != l.Warn ||
+		l.GetLevelByString("w9", l.Off) != l.Off {
+		t.Fatalf("unexpected name %q", l.GetLevelName(l.Warn))
+	}
+}
+
+func TestWithError(t *testing.T) {
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	defer l.SetPlain(false)
+	log.E.WithError(nil).Ln("no error")
+	log.E.WithError(errors.New("disk full")).Ln("failed to save")
+	log.E.WithError(fmt.Errorf("saving: %w", io.ErrShortWrite)).Ln("failed")
+	lines := r.Lines()
+	if strings.Contains(lines[0], "error=") ||
+		!strings.Contains(lines[1], ` failed to save error="disk full" `) ||
+		!strings.Contains(lines[2], ` failed error="saving: short write" error.cause="short write" `) {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSafeWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := l.SafeWriter(&b)
+	fw := &flushWriter{Writer: w}
+	l.SetOutput(l.SafeWriter(fw))
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			fmt.Fprintln(w, "written by the application")
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		log.I.Ln("written by the logger")
+	}
+	<-done
+	if err := l.Flush(); err != nil || !fw.flushed {
+		t.Fatalf("writer not flushed, err %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if line != "written by the application" &&
+			!strings.Contains(line, " written by the logger ") {
+			t.Fatalf("interleaved line %q", line)
+		}
+	}
+}
+
+func TestPrintLevelLegend(t *testing.T) {
+	l.SetPlain(true)
+	defer l.SetPlain(false)
+	var b bytes.Buffer
+	if err := l.PrintLevelLegend(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	var names, want []string
+	for _, line := range lines {
+		names = append(names, strings.Fields(line)[0])
+	}
+	for _, lvl := range l.AllLevels()[1:] {
+		want = append(want, l.GetLevelName(lvl))
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected legend %q", b.String())
+	}
+	if !strings.HasPrefix(lines[1], "ftl  an error that the program can't continue") {
+		t.Fatalf("unexpected legend %q", b.String())
+	}
+}
+
+func TestSetCallerMinLevel(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Debug)
+	defer l.SetLogLevel(l.Info)
+	l.SetCallerMinLevel(l.Warn)
+	defer l.SetCallerMinLevel(l.Trace)
+	log.W.Ln("located")
+	log.D.Ln("not located")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], "log_test.go:") ||
+		strings.Contains(lines[1], "log_test.go:") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	err := log.E.Errorf("bad config: %w", io.ErrUnexpectedEOF)
+	if err.Error() != "bad config: unexpected EOF" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if lines := r.Lines(); !strings.Contains(lines[0], " "+err.Error()+" ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if err := log.D.Errorf("quiet %d", 1); err == nil || err.Error() != "quiet 1" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	defer l.SetClock(nil)
+	before := l.FormatEntry(l.Info, "message", "file.go:1")
+	var b bytes.Buffer
+	l.SetLogLevel(l.Info)
+	l.SetOutput(&b)
+	c := l.Snapshot()
+	l.SetLogLevel(l.Trace)
+	l.SetOutput(io.Discard)
+	l.SetApp("other")
+	l.SetTimeStampNone()
+	l.SetLevelStyle(l.StyleShort)
+	l.SetFieldSeparator(" | ")
+	l.SetClock(time.Now)
+	l.SetByteUnits(l.UnitsSI)
+	l.Restore(c)
+	defer l.SetOutput(os.Stderr)
+	if s := l.Bytes(1536).String(); s != "1.5KiB" {
+		t.Fatalf("byte units not restored, got %s", s)
+	}
+	if l.GetLogLevel() != l.Info {
+		t.Fatalf("level not restored, %d", l.GetLogLevel())
+	}
+	log.I.Ln("restored")
+	if !strings.Contains(b.String(), " restored ") {
+		t.Fatalf("output not restored, got %q", b.String())
+	}
+	after := l.FormatEntry(l.Info, "message", "file.go:1")
+	if after != before {
+		t.Fatalf("format not restored, got %q, want %q", after, before)
+	}
+}
+
+func TestSetPreamble(t *testing.T) {
+	l.SetPreamble(func() string { return "# testing 1.0 on host" })
+	defer l.SetPreamble(nil)
+	var main, extra bytes.Buffer
+	l.SetOutput(&main)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	remove := l.AddOutput(&extra)
+	defer remove()
+	log.I.Ln("first entry")
+	for _, b := range []*bytes.Buffer{&main, &extra} {
+		lines := strings.Split(b.String(), "\n")
+		if lines[0] != "# testing 1.0 on host" || !strings.Contains(lines[1], " first entry ") {
+			t.Fatalf("unexpected output %q", b.String())
+		}
+	}
+}
+
+func TestSetNilString(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	var j bytes.Buffer
+	remove := l.AddOutput(&j, l.WithFormat(l.FormatJSON))
+	defer remove()
+	l.SetNilString("nil")
+	defer l.SetNilString("<nil>")
+	slog.New(l.NewSlogHandler(l.Info)).Info(
+		"done", "user", nil, "ok", true, "took", time.Second,
+	)
+	if lines := r.Lines(); !strings.Contains(lines[0], " done user=nil ok=true took=1s ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(j.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m["user"]; !ok || v != nil || m["ok"] != true || m["took"] != "1s" {
+		t.Fatalf("unexpected JSON entry %v", m)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	r := l.RingBuffer(10)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	quiet := l.StartHeartbeat(time.Millisecond, l.Debug, "filtered")
+	stop := l.StartHeartbeat(time.Millisecond, l.Info, "alive")
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	stop()
+	quiet()
+	n := len(r.Lines())
+	time.Sleep(5 * time.Millisecond)
+	lines := r.Lines()
+	if n == 0 || len(lines) != n {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, " alive") {
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestFramedWriter(t *testing.T) {
+	var b bytes.Buffer
+	l.SetOutput(l.NewFramedWriter(&b))
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetApp("testing")
+	l.GetSubsystemLogger("db").W.Ln("slow query")
+	log.I.Ln("second")
+	f, err := l.DecodeFrame(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Level != l.Warn || f.App != "testing" || f.Subsystem != "db" ||
+		f.Msg != "slow query" || !strings.Contains(f.Loc, "log_test.go:") ||
+		time.Since(f.Time) > time.Minute {
+		t.Fatalf("unexpected frame %+v", f)
+	}
+	if f, err = l.DecodeFrame(&b); err != nil || f.Msg != "second" || f.Subsystem != "" {
+		t.Fatalf("unexpected frame %+v, err %v", f, err)
+	}
+	if _, err = l.DecodeFrame(&b); err != io.EOF {
+		t.Fatalf("got %v at the end, want EOF", err)
+	}
+}
+
+func TestNilColorizer(t *testing.T) {
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	spec := l.GetLevelSpec(l.Warn)
+	defer l.SetLevelSpec(l.Warn, spec)
+	l.SetLevelSpec(l.Warn, l.LevelSpec{})
+	log.W.Ln("set")
+	l.LevelSpecs[l.Warn] = l.LevelSpec{Name: "wrn"}
+	log.W.Ln("mutated")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " wrn set ") || !strings.Contains(lines[1], " wrn mutated ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestRingSubscribe(t *testing.T) {
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.I.Ln("before")
+	lines, cancel := r.Subscribe()
+	_, slowCancel := r.Subscribe()
+	defer slowCancel()
+	for i := 0; i < 300; i++ {
+		log.I.Ln("line", i)
+	}
+	if got := <-lines; !strings.Contains(got, " line 0 ") {
+		t.Fatalf("unexpected first line %q", got)
+	}
+	if r.Dropped() != 2*(300-256) {
+		t.Fatalf("dropped %d lines", r.Dropped())
+	}
+	cancel()
+	cancel()
+	n := 0
+	for range lines {
+		n++
+	}
+	if n != 255 {
+		t.Fatalf("%d lines left after cancelling", n)
+	}
+}
+
+func TestSWatch(t *testing.T) {
+	l.ResetWatches()
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	type state struct{ Count int }
+	log.I.SWatch("state", state{1})
+	log.I.SWatch("state", state{1})
+	log.I.SWatch("other", state{1})
+	log.I.SWatch("state", state{2})
+	lines := r.Lines()
+	if len(lines) != 3 || !strings.Contains(lines[0], " state changed:") ||
+		!strings.Contains(lines[1], " other changed:") ||
+		!strings.Contains(lines[2], "Count: (int) 2") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetAlignMessages(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	// relative timestamps are the same width for the default Instance and
+	// the new one.
+	l.SetTimeStampMode(l.TimeRelative)
+	l.ResetClock()
+	l.SetAlignMessages(true)
+	l.SetApp("testing")
+	// the entry without an app name comes first, so its padding can't depend
+	// on the entries printed before it.
+	l.New(l.WithOutput(r)).GetLogger().I.Ln("without app")
+	log.I.Ln("with app")
+	log.W.Ln("warning")
+	column := -1
+	for i, line := range r.Lines() {
+		i := strings.Index(line, []string{"without app", "with app", "warning"}[i])
+		if column >= 0 && i != column {
+			t.Fatalf("messages don't line up: %q", r.Lines())
+		}
+		column = i
+	}
+}
+
+func TestLog(t *testing.T) {
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	defer l.SetClock(nil)
+	l.Log(l.Debug, "filtered")
+	l.Log(l.Warn, "retry", 3)
+	log.W.Ln("retry", 3)
+	l.Logf(l.Error, "retry %d", 4)
+	log.E.F("retry %d", 4)
+	lines := r.Lines()
+	if len(lines) != 4 {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	for i := 0; i < 4; i += 2 {
+		// the entries differ only in the line number of the location.
+		want := lines[i+1][:strings.LastIndexByte(lines[i+1], ':')]
+		if lines[i][:strings.LastIndexByte(lines[i], ':')] != want {
+			t.Fatalf("Log output %q differs from %q", lines[i], lines[i+1])
+		}
+	}
+}
+
+func TestSetLogLevelClamp(t *testing.T) {
+	defer l.SetLogLevel(l.Info)
+	l.SetLogLevel(l.Level(-5))
+	if l.GetLogLevel() != l.Off {
+		t.Fatalf("level -5 set as %d", l.GetLogLevel())
+	}
+	l.SetLogLevel(l.Level(99))
+	levels := l.AllLevels()
+	if l.GetLogLevel() != levels[len(levels)-1] || !l.Enabled(l.Trace) {
+		t.Fatalf("level 99 set as %d", l.GetLogLevel())
+	}
+}
+
+func TestChannelWriter(t *testing.T) {
+	ch := make(chan l.Entry, 2)
+	l.SetOutput(l.NewChannelWriter(ch))
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	l.SetApp("testing")
+	log.D.Ln("filtered")
+	l.GetSubsystemLogger("db").E.Ln("connection lost")
+	fmt.Fprintln(l.NewChannelWriter(ch), "raw")
+	e := <-ch
+	if e.Level != l.Error || e.App != "testing" || e.Subsystem != "db" ||
+		e.Msg != "connection lost" || !strings.Contains(e.Loc, "log_test.go:") {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e = <-ch; e.Level != l.Info || e.Msg != "raw" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+}
+
+func TestAddHighlight(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.AddHighlight(regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`), func(s string) string { return "[" + s + "]" })
+	l.AddHighlight(regexp.MustCompile(`\d+`), func(s string) string { return "<" + s + ">" })
+	log.I.Ln("from 10.0.0.1 port 22")
+	l.SetPlain(true)
+	log.I.Ln("from 10.0.0.1 port 22")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " from [10.0.0.1] port <22> ") ||
+		!strings.Contains(lines[1], " from 10.0.0.1 port 22 ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetExitFunc(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Error)
+	var codes []int
+	l.SetExitFunc(func(code int) { codes = append(codes, code) })
+	l.SetExitCode(2)
+	log.F.Must(nil)
+	log.F.Ln("fatal")
+	log.F.Must(errors.New("must"))
+	l.SetLogLevel(l.Off)
+	log.F.F("not printed")
+	if fmt.Sprint(codes) != "[2 2 2]" || len(r.Lines()) != 2 {
+		t.Fatalf("exit codes %v, lines %q", codes, r.Lines())
+	}
+}
+
+func TestSetMinimalFormat(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	l.SetApp("testing")
+	l.SetMinimalFormat()
+	log.W.Ln("small")
+	l.SetVerboseFormat()
+	log.W.Ln("big")
+	lines := r.Lines()
+	if lines[0] != "W small" {
+		t.Fatalf("unexpected minimal line %q", lines[0])
+	}
+	if !regexp.MustCompile(`^\S+Z \[TESTING\] wrn big .+log_test\.go:\d+$`).MatchString(lines[1]) {
+		t.Fatalf("unexpected verbose line %q", lines[1])
+	}
+}
+
+func TestDurBytes(t *testing.T) {
+	defer l.SetByteUnits(l.UnitsBinary)
+	for v, want := range map[fmt.Stringer]string{
+		l.Dur(1500 * time.Millisecond):     "1.5s",
+		l.Dur(1234567891):                  "1.235s",
+		l.Dur(2*time.Millisecond + 345678): "2.346ms",
+		l.Dur(90 * time.Minute):            "1h30m0s",
+		l.Bytes(512):                       "512B",
+		l.Bytes(1468006):                   "1.4MiB",
+		l.Bytes(-2048):                     "-2.0KiB",
+	} {
+		if got := v.String(); got != want {
+			t.Errorf("%#v: got %q, want %q", v, got, want)
+		}
+	}
+	l.SetByteUnits(l.UnitsSI)
+	if got := l.Bytes(1468006).String(); got != "1.5MB" {
+		t.Errorf("got %q, want 1.5MB", got)
+	}
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	defer l.SetOutput(os.Stderr)
+	l.SetLogLevel(l.Info)
+	log.I.Ln("took", l.Dur(time.Second), "read", l.Bytes(2000))
+	if lines := r.Lines(); !strings.Contains(lines[0], " took 1s read 2.0kB ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetCollapseRepeats(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetCollapseRepeats(true)
+	for i := 0; i < 3; i++ {
+		log.I.Ln("in a loop")
+	}
+	log.I.Ln("in a loop")
+	log.I.Ln("after")
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lines := r.Lines()
+	if len(lines) != 3 || !strings.Contains(lines[0], " in a loop (x3) ") ||
+		!strings.Contains(lines[1], " in a loop ") ||
+		strings.Contains(lines[1], "(x") ||
+		!strings.Contains(lines[2], " after ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestFlushHeldBeforeSetOutput(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	var a, b, c bytes.Buffer
+	l.SetOutput(&a)
+	l.SetLogLevel(l.Info)
+	l.SetCollapseRepeats(true)
+	log.I.Ln("held back")
+	l.SetOutput(&b)
+	log.I.Ln("before raw")
+	l.Raw(l.Info, []byte("raw"))
+	l.SetCollapseRepeats(false)
+	l.SetDedup(time.Hour)
+	for i := 0; i < 2; i++ {
+		log.I.Ln("repeat")
+	}
+	l.SetOutput(&c)
+	if !strings.Contains(a.String(), " held back ") {
+		t.Fatalf("held back entry not written to its output %q", a.String())
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], " before raw ") ||
+		lines[1] != "raw" || !strings.Contains(lines[2], " repeat ") ||
+		!strings.Contains(lines[3], " last message repeated 1 times ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("entries written to the new output %q", c.String())
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.SetOutput(io.Discard)
+	l.SetColorDepth(l.Depth24)
+	if !l.ColorEnabled() {
+		t.Fatal("color is not enabled by default")
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l.SetOutput(f)
+	if l.ColorEnabled() {
+		t.Fatal("color is enabled for a file")
+	}
+	l.SetLogLevel(l.Info)
+	log.I.Ln("to a file")
+	if b, err := os.ReadFile(f.Name()); err != nil || strings.Contains(string(b), "\x1b") {
+		t.Fatalf("escapes written to a file %q %v", b, err)
+	}
+	l.SetOutput(io.Discard)
+	l.SetPlain(true)
+	if l.ColorEnabled() {
+		t.Fatal("color is enabled in plain mode")
+	}
+	l.SetPlain(false)
+	l.SetColorDepth(l.DepthNone)
+	if l.ColorEnabled() {
+		t.Fatal("color is enabled with no color depth")
+	}
+}
+
+func TestDailyFileWriter(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	dir := t.TempDir()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 23, 0, 0, 0, time.Local) }
+	old := filepath.Join(dir, "app-2024-01-10.log")
+	if err := os.WriteFile(old, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l.SetClock(func() time.Time { return day(15) })
+	w, err := l.NewDailyFileWriter(dir, "app", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	l.SetPreamble(func() string { return "# app" })
+	l.SetOutput(w)
+	l.SetLogLevel(l.Info)
+	log.I.Ln("on the 15th")
+	l.SetClock(func() time.Time { return day(16) })
+	log.I.Ln("on the 16th")
+	if _, err = os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("old file not pruned, %v", err)
+	}
+	for name, want := range map[string][]string{
+		"app-2024-01-15.log": {"# app", " on the 15th "},
+		"app-2024-01-16.log": {"# app", " on the 16th "},
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if len(lines) != 2 || lines[0] != want[0] || !strings.Contains(lines[1], want[1]) {
+			t.Fatalf("unexpected %s %q", name, b)
+		}
+	}
+}
+
+func TestSetSequenceNumbers(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetClock(func() time.Time { return time.Unix(0, 0) })
+	log.I.Ln("without")
+	l.SetSequenceNumbers(true)
+	log.I.Ln("one")
+	log.I.Ln("two")
+	log.I.Ln("three")
+	lines := r.Lines()
+	if strings.Contains(lines[0], " #") {
+		t.Fatalf("sequence number printed while disabled: %q", lines[0])
+	}
+	re := regexp.MustCompile(` #(\d+) (one|two|three) `)
+	var last uint64
+	for _, line := range lines[1:] {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("no sequence number in %q", line)
+		}
+		n, _ := strconv.ParseUint(m[1], 10, 64)
+		if n != last+1 && last != 0 {
+			t.Fatalf("sequence not consecutive in %q", lines)
+		}
+		last = n
+	}
+}
+
+func TestSetLevelClamps(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.SetLogLevel(l.Off)
+	sub := l.GetSubsystemLogger("clamped")
+	other := l.GetSubsystemLogger("clamped2")
+	sub.SetLevel(l.Level(99))
+	l.SetSubsystemLevel("clamped2", l.Level(99))
+	inst := l.New(l.WithLogLevel(l.Level(99)))
+	// a level that is not clamped would let through levels registered after
+	// it was set.
+	verbose := l.RegisterLevel("vrb", int(l.Trace), 0, 0, 0)
+	if !sub.T.Enabled() || sub.Printer(verbose).Enabled() {
+		t.Error("Logger level above the last one not clamped to Trace")
+	}
+	if !other.T.Enabled() || other.Printer(verbose).Enabled() {
+		t.Error("subsystem level above the last one not clamped to Trace")
+	}
+	if inst.GetLogLevel() != l.Trace {
+		t.Errorf("Instance level above the last one not clamped, %d", inst.GetLogLevel())
+	}
+}
+
+func TestMuteSubsystem(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.SetLogLevel(l.Info)
+	chatty := l.GetSubsystemLogger("chatty")
+	l.SetSubsystemLevel("chatty", l.Debug)
+	l.MuteSubsystem("chatty")
+	l.MuteSubsystem("chatty")
+	if chatty.E.Enabled() {
+		t.Fatal("muted subsystem still enabled")
+	}
+	l.SetSubsystemLevel("chatty", l.Trace)
+	l.UnmuteSubsystem("chatty")
+	if chatty.E.Enabled() {
+		t.Fatal("subsystem unmuted before every mute was undone")
+	}
+	l.UnmuteSubsystem("chatty")
+	if !chatty.T.Enabled() {
+		t.Fatal("level set while muted not restored")
+	}
+	l.UnmuteSubsystem("chatty")
+	if !chatty.T.Enabled() {
+		t.Fatal("unmuting an unmuted subsystem changed its level")
+	}
+}
+
+func TestDailyFileWriterSetClock(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	w, err := l.NewDailyFileWriter(t.TempDir(), "app", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	l.SetAsync(16)
+	defer l.SetAsync(0)
+	l.SetOutput(w)
+	l.SetLogLevel(l.Info)
+	// the writer reads the clock on the async goroutine while it is set.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.SetClock(time.Now)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		log.I.Ln("entry", i)
+	}
+	<-done
+}
+
+func TestDailyFileWriterCompression(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	dir := t.TempDir()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.Local) }
+	// left over by a run that stopped while compressing.
+	if err := os.WriteFile(
+		filepath.Join(dir, "app-2024-01-13.log"), []byte("13th\n"), 0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(dir, "app-2024-01-13.log.gz.tmp"), []byte("partial"), 0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+	l.SetClock(func() time.Time { return day(15) })
+	w, err := l.NewDailyFileWriter(dir, "app", 0, l.WithCompression(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("15th\n")); err != nil {
+		t.Fatal(err)
+	}
+	l.SetClock(func() time.Time { return day(16) })
+	if _, err = w.Write([]byte("16th\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	if got, want := strings.Join(names, " "),
+		"app-2024-01-13.log.gz app-2024-01-15.log.gz app-2024-01-16.log"; got != want {
+		t.Fatalf("got files %q, want %q", got, want)
+	}
+	for name, want := range map[string]string{
+		"app-2024-01-13.log.gz": "13th\n", "app-2024-01-15.log.gz": "15th\n",
+	} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil || string(b) != want {
+			t.Fatalf("%s holds %q, %v", name, b, err)
+		}
+	}
+}
+
+func TestSetGlobalFields(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	var j bytes.Buffer
+	defer l.AddOutput(&j, l.WithFormat(l.FormatJSON))()
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	l.SetGlobalFields("host", "web1", "error", "none")
+	log.I.Ln("started")
+	log.E.WithError(errors.New("disk full")).Ln("failed")
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " started host=web1 error=none ") ||
+		!strings.Contains(lines[1], ` failed host=web1 error="disk full" `) {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal(bytes.SplitN(j.Bytes(), []byte("\n"), 2)[0], &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["host"] != "web1" || e["msg"] != "started" {
+		t.Fatalf("unexpected JSON entry %v", e)
+	}
+}
+
+func TestAddSink(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.SetOutput(io.Discard)
+	l.SetLogLevel(l.Info)
+	l.AddRedaction(regexp.MustCompile(`hunter2`), "***")
+	var got []l.Entry
+	remove := l.AddSink(func(e l.Entry) { got = append(got, e) })
+	l.AddSink(func(l.Entry) { panic("broken sink") })
+	log.D.Ln("filtered")
+	l.GetSubsystemLogger("db").W.WithError(errors.New("disk full")).Ln("login hunter2")
+	remove()
+	log.W.Ln("after removal")
+	if len(got) != 1 {
+		t.Fatalf("got %d entries, want 1", len(got))
+	}
+	e := got[0]
+	if e.Level != l.Warn || e.Subsystem != "db" || e.Msg != "login ***" ||
+		e.Loc == "" || len(e.Fields) != 1 || e.Fields[0].Key != "error" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if err, ok := e.Fields[0].Value.(error); !ok || err.Error() != "disk full" {
+		t.Fatalf("unexpected error field %#v", e.Fields[0].Value)
+	}
+}
+
+func TestSetTimeZone(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	tokyo := time.FixedZone("JST", 9*60*60)
+	l.SetClock(func() time.Time { return time.Date(2024, 1, 15, 23, 0, 0, 0, tokyo) })
+	l.SetTimeStampFormat("2006-01-02T15:04Z07:00")
+	log.I.Ln("as given")
+	l.SetUTC()
+	log.I.Ln("in utc")
+	l.SetTimeZone(time.FixedZone("EST", -5*60*60))
+	log.I.Ln("in est")
+	lines := r.Lines()
+	for i, want := range []string{
+		"2024-01-15T23:00+09:00 ", "2024-01-15T14:00Z ", "2024-01-15T09:00-05:00 ",
+	} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("got %q, want it to start with %q", lines[i], want)
+		}
+	}
+}
+
+func TestSetLineWrapper(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	var b bytes.Buffer
+	l.SetOutput(&b)
+	l.SetLogLevel(l.Info)
+	l.SetLineWrapper("<<", ">>")
+	log.I.Ln("first line\nsecond line")
+	out := b.String()
+	if !strings.HasPrefix(out, "<<") || !strings.HasSuffix(out, ">>\n") ||
+		strings.Count(out, "<<") != 1 || strings.Count(out, "\n") != 2 {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+// causeError is an error whose text doesn't include that of the error it
+// wraps.
+type causeError struct{ cause error }
+
+func (e causeError) Error() string { return "request failed" }
+
+func (e causeError) Unwrap() error { return e.cause }
+
+func TestChkErrorChain(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	log.E.Chk(fmt.Errorf("saving: %w", io.ErrShortWrite))
+	log.E.Chk(fmt.Errorf("retrying: %w", causeError{io.ErrUnexpectedEOF}))
+	lines := r.Lines()
+	if strings.Contains(lines[0], "\n") {
+		t.Fatalf("cause already in the message printed again %q", lines[0])
+	}
+	if parts := strings.Split(lines[1], "\n"); len(parts) != 2 ||
+		!strings.Contains(parts[0], " retrying: request failed ") ||
+		parts[1] != "    unexpected EOF" {
+		t.Fatalf("unexpected error chain %q", lines[1])
+	}
+}
+
+func TestLevelLabel(t *testing.T) {
+	c := l.Snapshot()
+	defer l.Restore(c)
+	l.SetLevelName(l.Error, "ERROR")
+	defer l.SetLevelName(l.Error, "err")
+	var labels []string
+	for _, lvl := range []l.Level{
+		l.Off, l.Panic, l.Fatal, l.Error, l.Check, l.Warn, l.Info, l.Debug, l.Trace,
+	} {
+		labels = append(labels, l.LevelLabel(lvl))
+	}
+	if got := strings.Join(labels, " "); got != "off panic fatal error check warn info debug trace" {
+		t.Fatalf("unexpected labels %q", got)
+	}
+	notice := l.RegisterLevel("NTC", int(l.Info), 0, 0, 255)
+	if lbl := l.LevelLabel(notice); lbl != "ntc" {
+		t.Fatalf("unexpected label of a registered level %q", lbl)
+	}
+	l.Restore(c)
+	if lbl := l.LevelLabel(notice); lbl != "" {
+		t.Fatalf("label of a removed level %q", lbl)
+	}
+	if lbl := l.LevelLabel(l.Level(-5)); lbl != "" {
+		t.Fatalf("unexpected label of a number that is not a level %q", lbl)
+	}
+}
+
+func TestWithRequestLevel(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	traced := l.WithRequestLevel(context.Background(), l.Trace)
+	log.T.Ctx(context.Background()).Ln("untraced request")
+	log.T.Ctx(traced).Ln("traced request")
+	db := l.GetSubsystemLogger("requestdb")
+	l.SetSubsystemLevel("requestdb", l.Error)
+	db.D.Ctx(traced).Ln("traced query")
+	quiet := l.WithRequestLevel(context.Background(), l.Error)
+	log.I.Ctx(quiet).Ln("quiet request")
+	lines := r.Lines()
+	if len(lines) != 2 || !strings.Contains(lines[0], " traced request ") ||
+		!strings.Contains(lines[1], " traced query ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestCaptureStdlib(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	flags := stdlog.Flags()
+	restore := l.CaptureStdlib(l.Warn)
+	stdlog.Printf("from a dependency: %d", 42)
+	restore()
+	if stdlog.Flags() != flags || stdlog.Writer() != os.Stderr {
+		t.Fatal("standard logger not restored")
+	}
+	if lines := r.Lines(); len(lines) != 1 ||
+		!strings.HasSuffix(lines[0], " wrn from a dependency: 42") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestSetMessageFunc(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetMessageFunc(func(sep string, a ...interface{}) string {
+		var parts []string
+		for _, v := range a {
+			b, _ := json.Marshal(v)
+			parts = append(parts, string(b))
+		}
+		return strings.Join(parts, sep)
+	})
+	log.I.Ln("user", map[string]int{"id": 7})
+	l.SetMessageFunc(nil)
+	log.I.Ln("user", map[string]int{"id": 7})
+	lines := r.Lines()
+	if !strings.Contains(lines[0], ` "user" {"id":7} `) ||
+		!strings.Contains(lines[1], " user map[id:7] ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	l.ResetOnce()
+	r := l.RingBuffer(4)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Error)
+	warn := func(n int) { log.W.Once("deprecated", n) }
+	warn(0)
+	l.SetLogLevel(l.Info)
+	for i := 1; i <= 3; i++ {
+		warn(i)
+	}
+	log.W.Once("other site")
+	lines := r.Lines()
+	if len(lines) != 2 || !strings.Contains(lines[0], " deprecated 1 ") ||
+		!strings.Contains(lines[1], " other site ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestJournald(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	dir, err := os.MkdirTemp("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	defer func(s string) { *l.JournaldSocket = s }(*l.JournaldSocket)
+	*l.JournaldSocket = socket
+	j, err := l.NewJournaldWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	l.SetOutput(j)
+	l.SetLogLevel(l.Info)
+	l.SetApp("testing")
+	l.GetSubsystemLogger("journaldb").W.Ln("slow\nquery")
+	log.E.Ln("failed")
+	buf := make([]byte, 4096)
+	for _, want := range [][]string{
+		{"PRIORITY=4\n", "MESSAGE\n", "slow\nquery\n", "SYSLOG_IDENTIFIER=testing\n",
+			"LOG_SUBSYSTEM=journaldb\n", "CODE_FILE="},
+		{"PRIORITY=3\n", "MESSAGE=failed\n", "LOG_SUBSYSTEM=testing\n"},
+	} {
+		_ = journal.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := journal.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range want {
+			if !bytes.Contains(buf[:n], []byte(field)) {
+				t.Fatalf("datagram %q has no %q", buf[:n], field)
+			}
+		}
+	}
+}
+
+func TestSetSpewConfig(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetSpewConfig(&spew.ConfigState{Indent: "\t", SortKeys: true})
+	log.I.S(map[string]int{"b": 2, "a": 1, "c": 3})
+	l.SetSpewConfig(nil)
+	log.I.S(map[string]int{"a": 1})
+	lines := r.Lines()
+	a, b, c := strings.Index(lines[0], `"a"`), strings.Index(lines[0], `"b"`), strings.Index(lines[0], `"c"`)
+	if !strings.Contains(lines[0], "\n    \t(string)") || a > b || b > c {
+		t.Fatalf("spew config not used %q", lines[0])
+	}
+	if strings.Contains(lines[1], "\t(string)") || !strings.Contains(lines[1], " (string) (len=1) \"a\"") {
+		t.Fatalf("spew defaults not restored %q", lines[1])
+	}
+}
+
+func TestWithLevel(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(3)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.WithLevel(l.Trace, func() { log.T.Ln("inside") })
+	log.T.Ln("outside")
+	func() {
+		defer func() { _ = recover() }()
+		l.WithLevel(l.Off, func() { panic("in fn") })
+	}()
+	if l.GetLogLevel() != l.Info {
+		t.Fatalf("level not restored after a panic, %d", l.GetLogLevel())
+	}
+	if lines := r.Lines(); len(lines) != 1 || !strings.Contains(lines[0], " inside ") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestAddRedaction(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(2)
+	l.SetOutput(r)
+	var j bytes.Buffer
+	defer l.AddOutput(&j, l.WithFormat(l.FormatJSON))()
+	l.SetLogLevel(l.Info)
+	l.AddRedaction(regexp.MustCompile(`token=\w+`), "token=REDACTED")
+	// redactions apply in order, so this one sees the result of the first.
+	l.AddRedaction(regexp.MustCompile(`REDACTED`), "[hidden]")
+	log.I.Ln("calling with token=abc123")
+	log.I.S(struct{ Password string }{"hunter2 token=abc123"})
+	lines := r.Lines()
+	if !strings.Contains(lines[0], " calling with token=[hidden] ") ||
+		!strings.Contains(lines[1], `"hunter2 token=[hidden]"`) ||
+		strings.Contains(lines[0]+lines[1], "abc123") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if strings.Contains(j.String(), "abc123") || !strings.Contains(j.String(), "token=[hidden]") {
+		t.Fatalf("JSON output not redacted %q", j.String())
+	}
+}
+
+func TestSetFieldSeparator(t *testing.T) {
+	defer l.Restore(l.Snapshot())
+	r := l.RingBuffer(1)
+	l.SetOutput(r)
+	l.SetLogLevel(l.Info)
+	l.SetPlain(true)
+	l.SetApp("testing")
+	l.SetClock(func() time.Time { return time.Unix(0, 0).UTC() })
+	l.SetTimeStampFormat("15:04")
+	l.SetLocFormat(l.LocFileLine)
+	l.SetFieldSeparator(" | ")
+	log.I.Ln("separated")
+	if line := r.Lines()[0]; !regexp.MustCompile(
+		`^00:00 \| \[TESTING\] \| inf \| separated \| log_test\.go:\d+$`,
+	).MatchString(line) {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
@@ -0,0 +1,36 @@
+// Package logtest has helpers for using the log package in tests, kept apart
+// so that programs that log don't link the testing package.
+package logtest
+
+import (
+	"testing"
+
+	"github.com/mleku/log"
+)
+
+// FailOnLevel makes any entry at level or more severe, such as Error, fail the
+// test t, to catch code that starts logging errors it shouldn't. The hook is
+// removed when the test finishes. Entries may be logged from any goroutine.
+func FailOnLevel(t testing.TB, level log.Level) {
+	t.Helper()
+	// hooks run while the log output lock is held, so the names and order of
+	// the levels are looked up now.
+	levels := log.AllLevels()
+	names := make(map[log.Level]string, len(levels))
+	ranks := make(map[log.Level]int, len(levels))
+	for i, lvl := range levels {
+		names[lvl], ranks[lvl] = log.GetLevelName(lvl), i
+	}
+	threshold, ok := ranks[level]
+	if !ok {
+		threshold = len(levels)
+	}
+	remove := log.AddHook(
+		func(l log.Level, msg string, loc string) {
+			if r, ok := ranks[l]; ok && r <= threshold {
+				t.Errorf("unexpected %s log entry: %s %s", names[l], msg, loc)
+			}
+		},
+	)
+	t.Cleanup(remove)
+}
@@ -0,0 +1,45 @@
+package logtest_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mleku/log"
+	"github.com/mleku/log/logtest"
+)
+
+// fakeTB records the errors of a test instead of failing it.
+type fakeTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, a ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, a...))
+}
+
+func (f *fakeTB) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+
+func TestFailOnLevel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	log.SetLogLevel(log.Info)
+	l := log.GetLogger()
+	tb := &fakeTB{}
+	logtest.FailOnLevel(tb, log.Error)
+	l.W.Ln("warnings are allowed")
+	l.E.Ln("disk full")
+	for _, fn := range tb.cleanups {
+		fn()
+	}
+	l.E.Ln("after the test")
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "unexpected err log entry: disk full ") {
+		t.Fatalf("unexpected errors %q", tb.errors)
+	}
+}
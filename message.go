@@ -0,0 +1,25 @@
+package log
+
+import (
+	"github.com/mleku/atomic"
+)
+
+// MessageFunc makes the message of an entry from the items given to printers
+// such as Ln, with sep between them.
+type MessageFunc func(sep string, a ...interface{}) string
+
+// messageFunc is the function set by SetMessageFunc.
+var messageFunc atomic.Pointer[MessageFunc]
+
+// SetMessageFunc sets the function that makes messages from the items given to
+// the Ln and LnIf printers, Group.Ln and Log, such as to print some types in
+// a way of their own or to encode values as JSON. A nil fn, the default,
+// prints each item as fmt.Sprint does, with nil values as set with
+// SetNilString.
+func SetMessageFunc(fn MessageFunc) {
+	if fn == nil {
+		messageFunc.Store(nil)
+		return
+	}
+	messageFunc.Store(&fn)
+}
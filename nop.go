@@ -0,0 +1,67 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/mleku/atomic"
+)
+
+// NewNop returns a Logger that never prints. Its printers are empty functions
+// that don't look at the level or build a message, so swapping a subsystem's
+// Logger for a Nop one is the cheapest way to turn its logging off, cheaper
+// than setting its level to Off. The Chk printers still return whether there
+// is an error, Must and F still exit and P still panics, as code relies on
+// those.
+func NewNop() (l *Logger) {
+	lvl := atomic.NewInt32(int32(Off))
+	nop := func(level Level) LevelPrinter {
+		return nopPrinter(printerConfig{level: level, override: lvl, inst: std})
+	}
+	return &Logger{
+		P:    newPrinter(printerConfig{level: Panic, override: lvl, inst: std}),
+		F:    newPrinter(printerConfig{level: Fatal, override: lvl, inst: std}),
+		E:    nop(Error),
+		W:    nop(Warn),
+		I:    nop(Info),
+		D:    nop(Debug),
+		T:    nop(Trace),
+		lvl:  lvl,
+		inst: std,
+	}
+}
+
+// nopPrinter returns a LevelPrinter whose functions do nothing. Printers made
+// from it, such as with Prefix, Span or Ctx, or by Clone, do nothing too.
+func nopPrinter(c printerConfig) LevelPrinter {
+	c.nop = true
+	return LevelPrinter{
+		Ln:   func(a ...interface{}) {},
+		F:    func(format string, a ...interface{}) {},
+		LnIf: func(cond bool, a ...interface{}) {},
+		Once: func(a ...interface{}) {},
+		FIf:  func(cond bool, format string, a ...interface{}) {},
+		S:    func(a ...interface{}) {},
+		KV:   func(kv map[string]interface{}) {},
+		C:    func(closure func() string) {},
+		Chk:  func(e error) bool { return e != nil },
+		ChkDo: func(e error, onErr func()) bool {
+			if e != nil {
+				onErr()
+			}
+			return e != nil
+		},
+		Chkf: func(e error, format string, a ...interface{}) bool { return e != nil },
+		Must: func(e error) {
+			if e != nil {
+				fatalExit()
+			}
+		},
+		Errorf: func(format string, a ...interface{}) error {
+			return fmt.Errorf(format, a...)
+		},
+		Duration: func(name string) func() { return func() {} },
+		SDiff:    func(before, after interface{}) {},
+		SWatch:   func(key string, v interface{}) {},
+		cfg:      c,
+	}
+}
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"runtime"
+	"sync"
+)
+
+// onceSites are the call sites that have printed with Once, by program
+// counter.
+var onceSites sync.Map
+
+func _once(c printerConfig) Println {
+	return func(a ...interface{}) {
+		if c.skip() {
+			return
+		}
+		pc, _, _, _ := runtime.Caller(1)
+		if _, done := onceSites.LoadOrStore(pc, struct{}{}); done {
+			return
+		}
+		logPrint(c, joinStrings(" ", a...))()
+	}
+}
+
+// resetOnce forgets every call site that has printed with Once, for tests.
+func resetOnce() {
+	onceSites.Range(
+		func(pc, _ interface{}) bool {
+			onceSites.Delete(pc)
+			return true
+		},
+	)
+}
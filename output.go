@@ -0,0 +1,158 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The Format settings used with WithFormat
+const (
+	// FormatText writes entries as the usual log lines.
+	FormatText Format = iota
+	// FormatJSON writes each entry as a JSON object on one line.
+	FormatJSON
+)
+
+type (
+	// Format selects how entries are written to an output.
+	Format int
+	// OutputOption configures an output added with AddOutput.
+	OutputOption func(o *output)
+	// output is a writer added with AddOutput and its settings.
+	output struct {
+		id       uint64
+		w        io.Writer
+		format   Format
+		color    bool
+		minLevel Level
+	}
+)
+
+var (
+	// outputs are written to, in order, along with the writer of each level.
+	outputs  []*output
+	outputID uint64
+	// jsonKeys are the keys of the standard values of a JSON entry.
+	jsonKeys = map[string]bool{
+		"time": true, "level": true, "app": true, "seq": true,
+		"goroutine": true, "msg": true, "loc": true,
+	}
+)
+
+// WithFormat sets the format entries are written to the output in. The
+// default is FormatText.
+func WithFormat(f Format) OutputOption { return func(o *output) { o.format = f } }
+
+// WithColor sets whether text entries are colorized for the output. The
+// default is true, and colors are never used when they are off globally.
+func WithColor(color bool) OutputOption { return func(o *output) { o.color = color } }
+
+// WithMinLevel sets the least severe level written to the output, such as
+// Warn to send only warnings and worse to a remote sink. The default is Trace.
+func WithMinLevel(level Level) OutputOption {
+	return func(o *output) { o.minLevel = level }
+}
+
+// AddOutput adds w as an output that entries are written to, as well as the
+// writer set with SetOutput or SetLevelOutput, formatted according to opts. It
+// returns a function that removes the output again.
+func AddOutput(w io.Writer, opts ...OutputOption) (remove func()) {
+	o := &output{w: w, color: true, minLevel: Trace}
+	for _, opt := range opts {
+		opt(o)
+	}
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	flushHeld()
+	outputID++
+	o.id = outputID
+	outputs = append(outputs, o)
+	writePreamble(w)
+	return func() {
+		writerMx.Lock()
+		defer writerMx.Unlock()
+		flushHeld()
+		for i := range outputs {
+			if outputs[i].id == o.id {
+				outputs = append(outputs[:i:i], outputs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// appendJSON writes an entry to b as a JSON object, without a trailing
+// newline. Fields are added as keys after the standard ones, with "fields."
+// before the keys that are the same as a standard one. The caller must hold
+// writerMx.
+func appendJSON(b *bytes.Buffer, e *entry) {
+	start := b.Len()
+	b.WriteByte('{')
+	var t bytes.Buffer
+	if appendTimeText(&t, e.time, e.instance()) {
+		b.WriteString(`"time":`)
+		if e.instance().timeStampKind == timeStampEpoch && timeStampMode == TimeAbsolute {
+			b.Write(t.Bytes())
+		} else {
+			appendJSONValue(b, t.String())
+		}
+		b.WriteByte(',')
+	}
+	b.WriteString(`"level":`)
+	appendJSONValue(b, levelName(e.level))
+	if app := e.instance().app.Load(); app != "" {
+		b.WriteString(`,"app":`)
+		appendJSONValue(b, appText(app))
+	}
+	if e.seq != 0 {
+		b.WriteString(`,"seq":`)
+		b.Write(strconv.AppendUint(b.AvailableBuffer(), e.seq, 10))
+	}
+	if e.goroutine != 0 {
+		b.WriteString(`,"goroutine":`)
+		b.Write(strconv.AppendUint(b.AvailableBuffer(), e.goroutine, 10))
+	}
+	b.WriteString(`,"msg":`)
+	appendJSONValue(b, e.msg)
+	if e.loc != "" {
+		b.WriteString(`,"loc":`)
+		appendJSONValue(b, e.loc)
+	}
+	for _, f := range e.fields {
+		b.WriteByte(',')
+		if jsonKeys[f.key] {
+			// keep the standard key from being given twice.
+			appendJSONValue(b, "fields."+f.key)
+		} else {
+			appendJSONValue(b, f.key)
+		}
+		b.WriteByte(':')
+		appendJSONValue(b, f.value)
+	}
+	b.WriteByte('}')
+	if len(redactions) > 0 {
+		s := redact(b.String()[start:])
+		b.Truncate(start)
+		b.WriteString(s)
+	}
+}
+
+// appendJSONValue writes v to b as JSON. Errors and Stringers that don't
+// encode themselves are written as their text, as in text output, and values
+// that can't be encoded as their fmt.Sprint text.
+func appendJSONValue(b *bytes.Buffer, v interface{}) {
+	if _, ok := v.(json.Marshaler); !ok {
+		switch v.(type) {
+		case error, fmt.Stringer:
+			v = sprintArg(v)
+		}
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(j)
+}
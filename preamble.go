@@ -0,0 +1,46 @@
+package log
+
+import (
+	"io"
+	"strings"
+
+	"github.com/mleku/atomic"
+)
+
+// preamble is the function set by SetPreamble. It is read without holding
+// writerMx by writers that start new files.
+var preamble atomic.Pointer[func() string]
+
+// SetPreamble sets a function whose result is written as the first line of
+// each output when it is set with SetOutput or SetLevelOutput or added with
+// AddOutput, and of each new file of a DailyFileWriter, such as the app name,
+// version, start time and hostname, so that each log file says what wrote it.
+// A nil fn, the default, writes nothing.
+func SetPreamble(fn func() string) {
+	if fn == nil {
+		preamble.Store(nil)
+		return
+	}
+	preamble.Store(&fn)
+}
+
+// preambleLine returns the preamble line, with a newline, or nil if there is
+// no preamble.
+func preambleLine() []byte {
+	fn := preamble.Load()
+	if fn == nil {
+		return nil
+	}
+	return []byte(strings.TrimRight((*fn)(), "\n") + "\n")
+}
+
+// writePreamble writes the preamble, if there is one, to w. The caller must
+// hold writerMx.
+func writePreamble(w io.Writer) {
+	if w == nil {
+		return
+	}
+	if line := preambleLine(); line != nil {
+		writeRaw(w, line)
+	}
+}
@@ -0,0 +1,38 @@
+package log
+
+// showApp is set by SetShowApp.
+var showApp = true
+
+// SetShowApp sets whether text entries show the app name. The default is to
+// show it when one is set.
+func SetShowApp(show bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	showApp = show
+}
+
+// SetMinimalFormat sets entries to the shortest form, the first letter of the
+// level and the message, with no timestamp, app name or code location, such as
+// for small embedded targets. It is the same as setting each of those with
+// their own functions, which can change them again afterwards.
+func SetMinimalFormat() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	std.timeStampKind = timeStampNone
+	levelStyle = StyleShort
+	showApp = false
+	callerMinLevel.Store(int32(Off))
+}
+
+// SetVerboseFormat sets entries back to the full form, with the default
+// timestamp, the app name, the full level name and the code location of all
+// entries.
+func SetVerboseFormat() {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	std.timeStampFormat = defaultTimeStampFormat
+	std.timeStampKind = timeStampLayout
+	levelStyle = StyleFull
+	showApp = true
+	callerMinLevel.Store(noLevel)
+}
@@ -0,0 +1,41 @@
+package log
+
+import (
+	"bytes"
+	"io"
+)
+
+// Raw writes b, which is already a formatted log line such as one from another
+// logger, to the output for level and to the outputs added with AddOutput
+// whose minimum level it passes, followed by a newline. Nothing is added to
+// it, and it doesn't pass through hooks, redaction or deduplication. Nothing is
+// written if level is not printed at the log level.
+func Raw(level Level, b []byte) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if !asSevere(level, Level(std.level.Load())) {
+		return
+	}
+	// write the entry held back by SetCollapseRepeats first, as it was logged
+	// before b.
+	flushCollapsed()
+	line := make([]byte, 0, len(b)+1)
+	line = append(line, bytes.TrimRight(b, "\n")...)
+	line = append(line, '\n')
+	writeRaw(levelWriter(level), line)
+	for _, o := range outputs {
+		if asSevere(level, o.minLevel) {
+			writeRaw(o.w, line)
+		}
+	}
+}
+
+// writeRaw writes line to w. The caller must hold writerMx.
+func writeRaw(w io.Writer, line []byte) {
+	doWrite(
+		func() {
+			_, err := w.Write(line)
+			checkWrite(w, line, err)
+		},
+	)
+}
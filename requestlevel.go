@@ -0,0 +1,35 @@
+package log
+
+import (
+	"context"
+
+	"github.com/mleku/atomic"
+)
+
+// requestLevelKey is the context key of the level set with WithRequestLevel.
+type requestLevelKey struct{}
+
+// WithRequestLevel returns a copy of ctx that carries level as the log level
+// for the printers got from it with LevelPrinter.Ctx, such as to trace one
+// request through a busy server without tracing every other one.
+func WithRequestLevel(ctx context.Context, level Level) context.Context {
+	return context.WithValue(ctx, requestLevelKey{}, level)
+}
+
+// Ctx returns a copy of the LevelPrinter that uses the level set in ctx with
+// WithRequestLevel instead of the level of its subsystem or the global level.
+// If ctx has no level the LevelPrinter is returned as it is.
+//
+// Go has no goroutine-local storage, so this only affects the entries printed
+// through Ctx, in code the context has been passed down to, such as
+//
+//	log.D.Ctx(ctx).Ln("cache miss", key)
+func (lp LevelPrinter) Ctx(ctx context.Context) LevelPrinter {
+	level, ok := ctx.Value(requestLevelKey{}).(Level)
+	if !ok {
+		return lp
+	}
+	c := lp.cfg
+	c.override = atomic.NewInt32(int32(level))
+	return newPrinter(c)
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Ring is an io.Writer that keeps the most recent log lines in memory, for
+// live debugging of a running process. It is bounded by the size given to
+// RingBuffer so it can't grow without limit.
+type Ring struct {
+	mx    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+	// subscribers get each line written after they subscribed.
+	subscribers map[chan string]struct{}
+	dropped     uint64
+}
+
+// subscriberBuffer is the number of lines a subscriber can fall behind by
+// before lines are dropped for it.
+const subscriberBuffer = 256
+
+// RingBuffer returns a Ring that holds the last size lines written to it. Use
+// it with SetOutput, combined with io.MultiWriter to keep the terminal output.
+func RingBuffer(size int) (r *Ring) {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{lines: make([]string, size)}
+}
+
+// Write stores p as one line, dropping the oldest line when the buffer is full.
+func (r *Ring) Write(p []byte) (n int, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	line := strings.TrimSuffix(string(p), "\n")
+	r.lines[r.next] = line
+	for c := range r.subscribers {
+		select {
+		case c <- line:
+		default:
+			r.dropped++
+		}
+	}
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered lines from oldest to newest.
+func (r *Ring) Lines() (lines []string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if !r.full {
+		return append(lines, r.lines[:r.next]...)
+	}
+	lines = append(lines, r.lines[r.next:]...)
+	return append(lines, r.lines[:r.next]...)
+}
+
+// Subscribe returns a channel that gets each line written to the Ring from now
+// on, such as for streaming the log to a live viewer, and a function that
+// unsubscribes and closes the channel. A subscriber that falls behind by more
+// than a few hundred lines misses the lines after that rather than holding up
+// logging, as counted by Dropped.
+func (r *Ring) Subscribe() (lines <-chan string, cancel func()) {
+	c := make(chan string, subscriberBuffer)
+	r.mx.Lock()
+	if r.subscribers == nil {
+		r.subscribers = map[chan string]struct{}{}
+	}
+	r.subscribers[c] = struct{}{}
+	r.mx.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(
+			func() {
+				r.mx.Lock()
+				defer r.mx.Unlock()
+				delete(r.subscribers, c)
+				close(c)
+			},
+		)
+	}
+}
+
+// Dropped returns the number of lines that subscribers missed because they
+// fell behind.
+func (r *Ring) Dropped() uint64 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.dropped
+}
+
+// Handler returns a http.HandlerFunc that writes the buffered lines as plain
+// text, such as for a /debug/log endpoint.
+func (r *Ring) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range r.Lines() {
+			_, _ = w.Write([]byte(line + "\n"))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package log
+
+import (
+	"io"
+	"sync"
+)
+
+// safeWriter is a writer whose writes are done one at a time.
+type safeWriter struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+// SafeWriter returns a writer that writes to w one call at a time, holding its
+// own lock, so that each line is written whole even if w is not safe for
+// concurrent use.
+//
+// The package never writes to its outputs from two goroutines at once, in
+// async mode as well, so SafeWriter is only needed when w is also written to
+// by other code, such as a file that the application writes to itself, or
+// when a hook writes to the same writer as the output. To share w that way,
+// the other code must write to the returned writer too. Flush passes through
+// to w.
+func SafeWriter(w io.Writer) io.Writer { return &safeWriter{w: w} }
+
+func (s *safeWriter) Write(p []byte) (n int, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.w.Write(p)
+}
+
+// Flush flushes or syncs the underlying writer, if it can be.
+func (s *safeWriter) Flush() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return flush(s.w)
+}
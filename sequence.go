@@ -0,0 +1,26 @@
+package log
+
+import (
+	"github.com/mleku/atomic"
+)
+
+var (
+	// sequenceNumbers is set by SetSequenceNumbers.
+	sequenceNumbers bool
+	// sequence is the sequence number of the last numbered entry.
+	sequence atomic.Uint64
+)
+
+// SetSequenceNumbers sets whether each entry shows a sequence number, as #N
+// after the level, that goes up by one with each entry of the process, so that
+// entries can be put in the order they were logged even when their timestamps
+// are the same, such as to follow the entries of goroutines that interleave.
+// In JSON it is the field "seq".
+//
+// As with the timestamp, the number is taken when the entry is logged, not
+// when it is written, so it holds in async mode too.
+func SetSequenceNumbers(on bool) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	sequenceNumbers = on
+}
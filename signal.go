@@ -0,0 +1,49 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// FlushOnSignal installs a signal handler that calls Flush when the process
+// gets one of the signals sig, or SIGINT or SIGTERM if none are given, so that
+// entries queued in async mode and lines buffered by the outputs are written
+// before the process stops. It then stops handling the signal and raises it
+// again, so that it has its usual effect.
+//
+// If the application handles the same signals with signal.Notify, those
+// handlers keep getting them, and as they are still registered when the
+// signal is raised again the process does not stop, but gets the signal a
+// second time. Such an application should call Flush in its own handler
+// rather than use FlushOnSignal. The returned function removes the handler.
+func FlushOnSignal(sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(
+			func() {
+				signal.Stop(c)
+				close(done)
+			},
+		)
+	}
+	go func() {
+		select {
+		case s := <-c:
+			_ = Flush()
+			stop()
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = p.Signal(s)
+			}
+		case <-done:
+		}
+	}()
+	return
+}
@@ -0,0 +1,83 @@
+package log
+
+type (
+	// Field is a key/value pair of an Entry.
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+	// Sink takes each entry as its separate parts, for outputs that don't
+	// take a stream of bytes, such as the client of a logging service or a
+	// database.
+	Sink func(e Entry)
+	// sink is a Sink added with AddSink.
+	sink struct {
+		id uint64
+		fn Sink
+	}
+)
+
+var (
+	// sinks are called in order on every entry that is written.
+	sinks  []sink
+	sinkID uint64
+)
+
+// AddSink adds fn as an output that is given every entry that is written, like
+// the outputs added with AddOutput, as an Entry whose message doesn't include
+// the fields of the entry, which are in Fields instead. Redactions apply to the
+// message and to the fields whose values are strings. It returns a function
+// that removes the sink again.
+//
+// Sinks are called while the log output lock is held, or in async mode by the
+// background goroutine, so they must not log themselves. A panic in a sink is
+// recovered and ignored.
+func AddSink(fn Sink) (remove func()) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	sinkID++
+	id := sinkID
+	sinks = append(sinks, sink{id, fn})
+	return func() {
+		writerMx.Lock()
+		defer writerMx.Unlock()
+		for i := range sinks {
+			if sinks[i].id == id {
+				sinks = append(sinks[:i:i], sinks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// writeSinks gives an entry to the sinks. The caller must hold writerMx.
+func writeSinks(e *entry) {
+	if len(sinks) == 0 {
+		return
+	}
+	r := Entry{
+		Time:      e.time,
+		Level:     e.level,
+		App:       e.instance().app.Load(),
+		Subsystem: e.subsystem,
+		Msg:       redact(e.msg),
+		Loc:       e.loc,
+	}
+	for _, f := range e.fields {
+		if s, ok := f.value.(string); ok {
+			f.value = redact(s)
+		}
+		r.Fields = append(r.Fields, Field{f.key, f.value})
+	}
+	for _, s := range sinks {
+		fn := s.fn
+		doWrite(func() { runSink(fn, r) })
+	}
+}
+
+// runSink calls a sink, recovering from any panic in it so that a broken sink
+// can't take down the logging call.
+func runSink(fn Sink, e Entry) {
+	defer func() { _ = recover() }()
+	fn(e)
+}
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+)
+
+// slogHandler is a slog.Handler that writes records as entries of this
+// package.
+type slogHandler struct {
+	level  Level
+	group  string
+	fields []field
+}
+
+// NewSlogHandler returns a slog.Handler that writes records through this
+// package's formatting and outputs, for records at or above level and the
+// global log level. Record attributes become fields, with the names of groups
+// joined to their keys with dots.
+//
+//	slog.SetDefault(slog.New(log.NewSlogHandler(log.Info)))
+func NewSlogHandler(level Level) slog.Handler { return &slogHandler{level: level} }
+
+// slogLevel maps a slog.Level to a Level.
+func slogLevel(l slog.Level) Level {
+	switch {
+	case l >= slog.LevelError:
+		return Error
+	case l >= slog.LevelWarn:
+		return Warn
+	case l >= slog.LevelInfo:
+		return Info
+	case l >= slog.LevelDebug:
+		return Debug
+	}
+	return Trace
+}
+
+func (h *slogHandler) Enabled(_ context.Context, l slog.Level) bool {
+	level := slogLevel(l)
+	return asSevere(level, h.level) && Enabled(level)
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	level := slogLevel(r.Level)
+	fields := h.fields[:len(h.fields):len(h.fields)]
+	r.Attrs(
+		func(a slog.Attr) bool {
+			fields = appendAttr(fields, h.group, a)
+			return true
+		},
+	)
+	var loc string
+	if r.PC != 0 && hasLoc(level) {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		loc = formatLoc(f.PC, f.File, f.Line)
+	}
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if !asSevere(level, h.level) || !asSevere(level, Level(std.level.Load())) {
+		return nil
+	}
+	e := &entry{
+		time: now(), level: level, msg: r.Message, fields: fields, loc: loc,
+	}
+	if !r.Time.IsZero() {
+		e.time = r.Time
+	}
+	if goroutineIDs {
+		e.goroutine = goroutineID()
+	}
+	emit(e)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.fields = h.fields[:len(h.fields):len(h.fields)]
+	for _, a := range attrs {
+		c.fields = appendAttr(c.fields, h.group, a)
+	}
+	return &c
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.group = h.group + name + "."
+	return &c
+}
+
+// appendAttr adds an attribute to fields, flattening groups into dotted keys.
+func appendAttr(fields []field, group string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendAttr(fields, group, ga)
+		}
+		return fields
+	}
+	return append(fields, field{group + a.Key, a.Value.Any()})
+}
@@ -0,0 +1,42 @@
+package log
+
+import "context"
+
+// SpanExtractor gets the trace and span IDs of the active span in a context,
+// returning false if there is none. For OpenTelemetry it is:
+//
+//	func(ctx context.Context) (traceID, spanID string, ok bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return sc.TraceID().String(), sc.SpanID().String(), sc.IsValid()
+//	}
+//
+// This keeps the tracing library out of the dependencies of this package.
+type SpanExtractor func(ctx context.Context) (traceID, spanID string, ok bool)
+
+var spanExtractor SpanExtractor
+
+// SetSpanExtractor sets the function LevelPrinter.Span uses to find the active
+// span in a context.
+func SetSpanExtractor(fn SpanExtractor) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	spanExtractor = fn
+}
+
+// Span returns a copy of the LevelPrinter that adds the trace_id and span_id
+// of the active span in ctx as fields to every entry, so they can be
+// correlated with traces. If there is no span, or no SpanExtractor has been
+// set, entries are unchanged.
+func (lp LevelPrinter) Span(ctx context.Context) LevelPrinter {
+	writerMx.Lock()
+	extract := spanExtractor
+	writerMx.Unlock()
+	if extract == nil {
+		return lp
+	}
+	traceID, spanID, ok := extract(ctx)
+	if !ok {
+		return lp
+	}
+	return lp.with("trace_id", traceID, "span_id", spanID)
+}
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	stdlog "log"
+)
+
+// entryLogger is an io.Writer that prints what is written to it as entries.
+type entryLogger struct {
+	level Level
+}
+
+// NewWriter returns an io.Writer that prints each write to it as the message
+// of an entry at level, without its trailing newline, such as for the output
+// of another logger. The entries have no code location, as the code that wrote
+// them is not known.
+func NewWriter(level Level) io.Writer { return entryLogger{level} }
+
+// Write prints p as an entry.
+func (w entryLogger) Write(p []byte) (n int, err error) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if asSevere(w.level, Level(std.level.Load())) {
+		msg := string(bytes.TrimSuffix(p, []byte("\n")))
+		emit(&entry{time: now(), level: w.level, msg: msg})
+	}
+	return len(p), nil
+}
+
+// CaptureStdlib makes what is logged with the standard library log package,
+// as many dependencies do, be printed as entries at level, with the format and
+// outputs of this package. The time and location flags of the standard logger
+// are turned off, as entries have their own timestamp. The returned function
+// puts back the output and flags the standard logger had before.
+func CaptureStdlib(level Level) (restore func()) {
+	w, flags := stdlog.Writer(), stdlog.Flags()
+	stdlog.SetOutput(NewWriter(level))
+	stdlog.SetFlags(0)
+	return func() {
+		stdlog.SetOutput(w)
+		stdlog.SetFlags(flags)
+	}
+}
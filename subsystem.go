@@ -0,0 +1,156 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mleku/atomic"
+)
+
+var (
+	// subsystems are the levels of the named subsystem Loggers.
+	subsystems = map[string]*atomic.Int32{}
+	// pendingLevels are levels set for subsystems that haven't registered
+	// yet, which are applied when they do.
+	pendingLevels = map[string]Level{}
+)
+
+// GetSubsystemLogger returns a Logger for the named subsystem, whose level can
+// be set separately from the global level with SetSubsystemLevel. Loggers for
+// the same name share their level.
+func GetSubsystemLogger(name string) (l *Logger) {
+	writerMx.Lock()
+	lvl, ok := subsystems[name]
+	if !ok {
+		lvl = atomic.NewInt32(noLevel)
+		if pl, ok := pendingLevels[name]; ok {
+			lvl.Store(int32(pl))
+			delete(pendingLevels, name)
+		}
+		subsystems[name] = lvl
+	}
+	writerMx.Unlock()
+	return newLogger(std, lvl, name)
+}
+
+// SetSubsystemLevel sets the level of the named subsystem. If the subsystem
+// has not registered yet, the level is applied when it does.
+func SetSubsystemLevel(name string, level Level) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	setSubsystemLevel(name, level)
+}
+
+// setSubsystemLevel is SetSubsystemLevel for callers that hold writerMx, and
+// returns false if the subsystem hasn't registered.
+func setSubsystemLevel(name string, level Level) (registered bool) {
+	level = clampLevel(level)
+	if m, ok := muted[name]; ok {
+		// the level is applied when the subsystem is unmuted.
+		m.level = level
+		_, registered = subsystems[name]
+		return
+	}
+	if lvl, ok := subsystems[name]; ok {
+		lvl.Store(int32(level))
+		return true
+	}
+	pendingLevels[name] = level
+	return
+}
+
+// Subsystems returns the names of the registered subsystems, sorted.
+func Subsystems() (names []string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// ApplyLevelConfig sets the levels of several subsystems at once from a map of
+// subsystem names to level names, such as {"net": "trc", "db": "wrn"}. The
+// returned error lists every bad level name and every subsystem that hasn't
+// registered. The levels of unregistered subsystems are still applied when
+// they register.
+func ApplyLevelConfig(config map[string]string) (err error) {
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	var errs []error
+	for _, name := range names {
+		level, e := parseLevel(config[name])
+		if e != nil {
+			errs = append(errs, fmt.Errorf("subsystem %q: %w", name, e))
+			continue
+		}
+		if !setSubsystemLevel(name, level) {
+			errs = append(errs, fmt.Errorf("unknown subsystem %q", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mute is the muting of a subsystem by MuteSubsystem.
+type mute struct {
+	// count is the number of calls to MuteSubsystem not yet matched by
+	// UnmuteSubsystem.
+	count int
+	// level is the level to put back when the subsystem is unmuted, noLevel
+	// if it had none.
+	level Level
+}
+
+// muted are the muted subsystems.
+var muted = map[string]*mute{}
+
+// MuteSubsystem silences the named subsystem until UnmuteSubsystem is called,
+// without losing its level. Mutes stack, so a subsystem muted twice needs to
+// be unmuted twice, and a level set with SetSubsystemLevel while it is muted
+// is the one it gets when unmuted.
+func MuteSubsystem(name string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	if m, ok := muted[name]; ok {
+		m.count++
+		return
+	}
+	m := &mute{count: 1, level: noLevel}
+	if lvl, ok := subsystems[name]; ok {
+		m.level = Level(lvl.Load())
+	} else if pl, ok := pendingLevels[name]; ok {
+		m.level = pl
+	}
+	setSubsystemLevel(name, Off)
+	muted[name] = m
+}
+
+// UnmuteSubsystem undoes a call to MuteSubsystem, putting back the level of
+// the subsystem once every call has been undone. It does nothing if the
+// subsystem is not muted.
+func UnmuteSubsystem(name string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	m, ok := muted[name]
+	if !ok {
+		return
+	}
+	if m.count--; m.count > 0 {
+		return
+	}
+	delete(muted, name)
+	if lvl, ok := subsystems[name]; ok {
+		lvl.Store(int32(m.level))
+	} else if m.level == noLevel {
+		delete(pendingLevels, name)
+	} else {
+		pendingLevels[name] = m.level
+	}
+}
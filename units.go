@@ -0,0 +1,67 @@
+package log
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mleku/atomic"
+)
+
+type (
+	// Dur is a time.Duration that prints rounded to three decimals of its
+	// largest unit, such as 1.235s, for readable log messages.
+	Dur time.Duration
+	// Bytes is a byte count that prints in the largest unit it has at least
+	// one of, such as 1.4MiB, in the units set with SetByteUnits.
+	Bytes int64
+	// ByteUnits selects the units Bytes prints in.
+	ByteUnits int32
+)
+
+// The ByteUnits settings used with SetByteUnits
+const (
+	// UnitsBinary prints powers of 1024: KiB, MiB, GiB and so on.
+	UnitsBinary ByteUnits = iota
+	// UnitsSI prints powers of 1000: kB, MB, GB and so on.
+	UnitsSI
+)
+
+// byteUnits is the ByteUnits set by SetByteUnits.
+var byteUnits atomic.Int32
+
+// SetByteUnits sets the units Bytes prints in. The default is UnitsBinary.
+func SetByteUnits(units ByteUnits) { byteUnits.Store(int32(units)) }
+
+func (d Dur) String() string {
+	t := time.Duration(d)
+	abs := t
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= time.Second:
+		t = t.Round(time.Millisecond)
+	case abs >= time.Millisecond:
+		t = t.Round(time.Microsecond)
+	}
+	return t.String()
+}
+
+func (n Bytes) String() string {
+	base, units := 1024.0, []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	if ByteUnits(byteUnits.Load()) == UnitsSI {
+		base, units = 1000.0, []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+	v := float64(n)
+	if v > -base && v < base {
+		return strconv.FormatInt(int64(n), 10) + "B"
+	}
+	var unit string
+	for _, unit = range units {
+		v /= base
+		if v > -base && v < base {
+			break
+		}
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64) + unit
+}
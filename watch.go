@@ -0,0 +1,69 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxWatches is the number of keys SWatch remembers dumps for. When there are
+// more, the least recently watched key is forgotten.
+const maxWatches = 1024
+
+// watchedValue is the last dump of a value watched with SWatch.
+type watchedValue struct {
+	key, dump string
+}
+
+var (
+	watchMx sync.Mutex
+	// watches are the watched values, the most recently watched first.
+	watches   = list.New()
+	watchKeys = map[string]*list.Element{}
+)
+
+func _swatch(c printerConfig) Watcher {
+	return func(key string, v interface{}) {
+		if c.skip() {
+			return
+		}
+		writerMx.Lock()
+		sc := spewConfig
+		writerMx.Unlock()
+		dump := sc.Sdump(v)
+		if !watchChanged(key, dump) {
+			return
+		}
+		logPrint(c, func() string { return key + " changed:\n" + dump })()
+	}
+}
+
+// watchChanged stores dump as the last dump of key and returns whether it is
+// different from the one before, or the first.
+func watchChanged(key, dump string) (changed bool) {
+	watchMx.Lock()
+	defer watchMx.Unlock()
+	if el, ok := watchKeys[key]; ok {
+		watches.MoveToFront(el)
+		w := el.Value.(*watchedValue)
+		if w.dump == dump {
+			return false
+		}
+		w.dump = dump
+		return true
+	}
+	watchKeys[key] = watches.PushFront(&watchedValue{key, dump})
+	if watches.Len() > maxWatches {
+		oldest := watches.Back()
+		watches.Remove(oldest)
+		delete(watchKeys, oldest.Value.(*watchedValue).key)
+	}
+	return true
+}
+
+// resetWatches forgets every watched value, for tests.
+func resetWatches() {
+	watchMx.Lock()
+	defer watchMx.Unlock()
+	watches.Init()
+	watchKeys = map[string]*list.Element{}
+}
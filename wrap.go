@@ -0,0 +1,14 @@
+package log
+
+// linePrefix and lineSuffix are set by SetLineWrapper.
+var linePrefix, lineSuffix string
+
+// SetLineWrapper sets text written before and after every text entry, such as
+// markers for a log collector to find where each entry starts and ends. An
+// entry of several lines, such as a spew dump, is wrapped as a whole, so that
+// its lines can be put back together. The default is no wrapping.
+func SetLineWrapper(prefix, suffix string) {
+	writerMx.Lock()
+	defer writerMx.Unlock()
+	linePrefix, lineSuffix = prefix, suffix
+}
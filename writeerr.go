@@ -0,0 +1,68 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/mleku/atomic"
+)
+
+var (
+	// failMx guards the state of failed writes, which is used by the async
+	// goroutine as well as the logging calls.
+	failMx            sync.Mutex
+	failing           = map[io.Writer]bool{}
+	failingCount      atomic.Int32
+	writeErrorHandler func(err error)
+)
+
+// SetWriteErrorHandler sets a function that is called with the error when
+// writing to an output fails. It is called once when an output starts failing,
+// and again only after it has had a successful write in between. The default,
+// or passing nil, prints the error to os.Stderr.
+//
+// Lines whose write failed are also written to os.Stderr, so they aren't lost.
+// The handler may be called with the log output lock held, so it must not log.
+func SetWriteErrorHandler(fn func(err error)) {
+	failMx.Lock()
+	defer failMx.Unlock()
+	writeErrorHandler = fn
+}
+
+// checkWrite records the result of a write of line to w, reporting err and
+// writing line to os.Stderr if it failed.
+func checkWrite(w io.Writer, line []byte, err error) {
+	if err == nil {
+		if failingCount.Load() > 0 && reflect.TypeOf(w).Comparable() {
+			failMx.Lock()
+			if failing[w] {
+				delete(failing, w)
+				failingCount.Dec()
+			}
+			failMx.Unlock()
+		}
+		return
+	}
+	comparable := reflect.TypeOf(w).Comparable()
+	failMx.Lock()
+	report := !comparable || !failing[w]
+	if report && comparable {
+		failing[w] = true
+		failingCount.Inc()
+	}
+	handler := writeErrorHandler
+	failMx.Unlock()
+	if report {
+		if handler != nil {
+			handler(err)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, "log: writing to output failed:", err)
+		}
+	}
+	if w != os.Stderr && len(line) > 0 {
+		_, _ = os.Stderr.Write(line)
+	}
+}